@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"dagger/argocd/internal/dagger"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applicationSetSpec holds the inputs of buildApplicationSet.
+type applicationSetSpec struct {
+	Name                 string
+	Namespace            string
+	Project              string
+	ClusterLabels        map[string]string
+	SourceType           string // git | helm
+	RepoURL              string
+	TargetRevision       string
+	Path                 string
+	Chart                string
+	ReleaseName          string
+	HelmValues           string
+	AppName              string
+	DestinationNamespace string
+	Automated            bool
+	Prune                bool
+	SelfHeal             bool
+	CreateNamespace      bool
+	ServerSideApply      bool
+}
+
+// RenderApplicationSet renders an Argo CD ApplicationSet with a cluster
+// generator that selects every registered cluster carrying clusterLabels —
+// the same JSON object passed to render-clusterbook-cluster-config, so a
+// cluster registered with {"env":"lab"} is picked up by an ApplicationSet
+// rendered with {"env":"lab"}. One Application is generated per matching
+// cluster, named <cluster>-<name> by default.
+//
+// The source is either a git path (sourceType=git) or a Helm chart from a
+// Helm or OCI registry (sourceType=helm; an oci:// repoURL is passed to
+// Argo CD without the scheme, the repository credential needs enableOCI).
+//
+// With --commit-to-git the manifest is committed via commit-config
+// (branch + optional PR/merge). Returns the manifest as a Dagger File.
+func (m *Argocd) RenderApplicationSet(
+	ctx context.Context,
+	// ApplicationSet name (also the default app name suffix and destination namespace)
+	name string,
+	// Namespace of the ApplicationSet (the Argo CD namespace)
+	// +optional
+	// +default="argocd"
+	namespace string,
+	// Argo CD project of the generated Applications
+	// +optional
+	// +default="default"
+	project string,
+	// JSON object literal used as matchLabels, e.g. {"env":"lab"} (empty selects all registered clusters, not in-cluster)
+	// +optional
+	clusterLabels string,
+
+	// --- Source ---
+
+	// "git" or "helm"
+	// +optional
+	// +default="git"
+	sourceType string,
+	// Git repository URL, Helm repository URL or oci:// registry URL
+	repoURL string,
+	// Git revision or chart version
+	// +optional
+	// +default="HEAD"
+	targetRevision string,
+	// Path within the git repository (sourceType=git)
+	// +optional
+	path string,
+	// Chart name (sourceType=helm)
+	// +optional
+	chart string,
+	// Helm release name (defaults to the chart name)
+	// +optional
+	releaseName string,
+	// Helm values file (sourceType=helm)
+	// +optional
+	helmValues *dagger.File,
+
+	// --- Destination + sync policy ---
+
+	// Generated Application name; go-template, cluster fields available as {{.name}}, {{.server}}, {{index .metadata.labels "env"}}
+	// +optional
+	appName string,
+	// Destination namespace (defaults to name)
+	// +optional
+	destinationNamespace string,
+	// Enable automated sync
+	// +optional
+	// +default=true
+	automated bool,
+	// +optional
+	// +default=true
+	prune bool,
+	// +optional
+	// +default=true
+	selfHeal bool,
+	// +optional
+	// +default=true
+	createNamespace bool,
+	// +optional
+	// +default=false
+	serverSideApply bool,
+
+	// --- Commit step (optional) ---
+
+	// Commit the rendered ApplicationSet
+	// +optional
+	// +default=false
+	commitToGit bool,
+	// +optional
+	repository string,
+	// +optional
+	gitToken *dagger.Secret,
+	// +optional
+	// +default="main"
+	branchName string,
+	// +optional
+	// +default="argocd/applicationsets/"
+	destinationPath string,
+	// File name under destinationPath (defaults to <name>.yaml)
+	// +optional
+	fileName string,
+	// +optional
+	// +default="Add Argo CD ApplicationSet"
+	commitMessage string,
+	// +optional
+	// +default=false
+	createPR bool,
+	// +optional
+	// +default="main"
+	baseBranch string,
+	// +optional
+	prTitle string,
+	// +optional
+	prBody string,
+	// +optional
+	// +default=false
+	mergePR bool,
+	// squash | merge | rebase
+	// +optional
+	// +default="squash"
+	mergeMethod string,
+) (*dagger.File, error) {
+	spec := applicationSetSpec{
+		Name:                 name,
+		Namespace:            namespace,
+		Project:              project,
+		SourceType:           sourceType,
+		RepoURL:              repoURL,
+		TargetRevision:       targetRevision,
+		Path:                 path,
+		Chart:                chart,
+		ReleaseName:          releaseName,
+		AppName:              appName,
+		DestinationNamespace: destinationNamespace,
+		Automated:            automated,
+		Prune:                prune,
+		SelfHeal:             selfHeal,
+		CreateNamespace:      createNamespace,
+		ServerSideApply:      serverSideApply,
+	}
+	if clusterLabels != "" {
+		if err := json.Unmarshal([]byte(clusterLabels), &spec.ClusterLabels); err != nil {
+			return nil, fmt.Errorf("cluster-labels: expected a JSON object of strings: %w", err)
+		}
+	}
+	if helmValues != nil {
+		values, err := helmValues.Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("read helm-values: %w", err)
+		}
+		spec.HelmValues = values
+	}
+
+	manifest, err := buildApplicationSet(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if fileName == "" {
+		fileName = name + ".yaml"
+	}
+	rendered := dag.Directory().
+		WithNewFile(fileName, manifest).
+		File(fileName)
+
+	if commitToGit {
+		if repository == "" {
+			return nil, fmt.Errorf("commit-to-git=true requires --repository")
+		}
+		if gitToken == nil {
+			return nil, fmt.Errorf("commit-to-git=true requires --git-token")
+		}
+		if _, err := m.CommitConfig(
+			ctx, rendered, repository, gitToken,
+			branchName, destinationPath, fileName, commitMessage,
+			createPR, baseBranch, prTitle, prBody,
+			mergePR, mergeMethod,
+		); err != nil {
+			return nil, fmt.Errorf("commit-to-git: %w", err)
+		}
+	}
+
+	return rendered, nil
+}
+
+// buildApplicationSet validates spec, applies defaults and returns the
+// ApplicationSet manifest.
+func buildApplicationSet(spec applicationSetSpec) (string, error) {
+	if spec.Name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	if spec.RepoURL == "" {
+		return "", fmt.Errorf("repo-url is required")
+	}
+	if spec.Namespace == "" {
+		spec.Namespace = "argocd"
+	}
+	if spec.Project == "" {
+		spec.Project = "default"
+	}
+	if spec.TargetRevision == "" {
+		spec.TargetRevision = "HEAD"
+	}
+	if spec.AppName == "" {
+		spec.AppName = "{{.name}}-" + spec.Name
+	}
+	if spec.DestinationNamespace == "" {
+		spec.DestinationNamespace = spec.Name
+	}
+
+	source := map[string]any{
+		"repoURL":        spec.RepoURL,
+		"targetRevision": spec.TargetRevision,
+	}
+	switch spec.SourceType {
+	case "", "git":
+		if spec.Path == "" {
+			return "", fmt.Errorf("source-type=git requires --path")
+		}
+		if spec.HelmValues != "" {
+			return "", fmt.Errorf("helm-values is only supported with source-type=helm")
+		}
+		source["path"] = spec.Path
+	case "helm":
+		if spec.Chart == "" {
+			return "", fmt.Errorf("source-type=helm requires --chart")
+		}
+		// Argo CD addresses OCI Helm repositories without the scheme.
+		source["repoURL"] = strings.TrimPrefix(spec.RepoURL, "oci://")
+		source["chart"] = spec.Chart
+		helm := map[string]any{}
+		if spec.ReleaseName != "" {
+			helm["releaseName"] = spec.ReleaseName
+		}
+		if spec.HelmValues != "" {
+			helm["values"] = spec.HelmValues
+		}
+		if len(helm) > 0 {
+			source["helm"] = helm
+		}
+	default:
+		return "", fmt.Errorf("unknown source-type %q (use git|helm)", spec.SourceType)
+	}
+
+	syncPolicy := map[string]any{}
+	if spec.Automated {
+		syncPolicy["automated"] = map[string]any{
+			"prune":    spec.Prune,
+			"selfHeal": spec.SelfHeal,
+		}
+	}
+	var syncOptions []string
+	if spec.CreateNamespace {
+		syncOptions = append(syncOptions, "CreateNamespace=true")
+	}
+	if spec.ServerSideApply {
+		syncOptions = append(syncOptions, "ServerSideApply=true")
+	}
+	if len(syncOptions) > 0 {
+		syncPolicy["syncOptions"] = syncOptions
+	}
+
+	// An empty selector would also match the local in-cluster entry, so
+	// without clusterLabels only clusters registered via a cluster Secret
+	// are selected.
+	matchLabels := spec.ClusterLabels
+	if len(matchLabels) == 0 {
+		matchLabels = map[string]string{"argocd.argoproj.io/secret-type": "cluster"}
+	}
+	selector := map[string]any{"matchLabels": matchLabels}
+
+	template := map[string]any{
+		"metadata": map[string]any{
+			"name": spec.AppName,
+		},
+		"spec": map[string]any{
+			"project": spec.Project,
+			"source":  source,
+			"destination": map[string]any{
+				"server":    "{{.server}}",
+				"namespace": spec.DestinationNamespace,
+			},
+		},
+	}
+	if len(syncPolicy) > 0 {
+		template["spec"].(map[string]any)["syncPolicy"] = syncPolicy
+	}
+
+	doc := map[string]any{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "ApplicationSet",
+		"metadata": map[string]any{
+			"name":      spec.Name,
+			"namespace": spec.Namespace,
+		},
+		"spec": map[string]any{
+			"goTemplate":        true,
+			"goTemplateOptions": []string{"missingkey=error"},
+			"generators": []any{
+				map[string]any{"clusters": map[string]any{"selector": selector}},
+			},
+			"template": template,
+		},
+	}
+
+	data, err := encodeYAML(doc)
+	if err != nil {
+		return "", fmt.Errorf("marshal applicationset: %w", err)
+	}
+	return data, nil
+}
+
+// encodeYAML marshals doc with the two-space indentation kubectl and the
+// KCL modules emit.
+func encodeYAML(doc any) (string, error) {
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -69,6 +69,27 @@ func (m *Argocd) BootstrapClusterbookCluster(
 	// +default="argocd"
 	deployNamespace string,
 
+	// --- Verify step (optional, requires deploy=true) ---
+
+	// Wait until Argo CD reports a Successful connection to the cluster
+	// (see verify-cluster-connection)
+	// +optional
+	// +default=false
+	verifyConnection bool,
+	// argocd-server URL — required when verifyConnection=true
+	// +optional
+	argocdServer string,
+	// Argo CD API token — required when verifyConnection=true
+	// +optional
+	argocdAuthToken *dagger.Secret,
+	// Skip TLS verification of argocd-server
+	// +optional
+	// +default=false
+	argocdInsecure bool,
+	// +optional
+	// +default="5m"
+	verifyTimeout string,
+
 	// --- Auto-detect network-key step (optional) ---
 
 	// Run kubectl get nodes -o json against --kube-config and use the
@@ -170,6 +191,25 @@ func (m *Argocd) BootstrapClusterbookCluster(
 	cacheBuster string,
 ) (*dagger.File, error) {
 	_ = cacheBuster
+
+	// Validate the verify step up front so a bad flag combination fails
+	// before anything is rendered, applied or committed.
+	verifyTarget := clusterName
+	if verifyTarget == "" {
+		verifyTarget = name
+	}
+	if verifyConnection {
+		if !deploy {
+			return nil, fmt.Errorf("verify-connection=true requires --deploy=true")
+		}
+		if argocdServer == "" || argocdAuthToken == nil {
+			return nil, fmt.Errorf("verify-connection=true requires --argocd-server and --argocd-auth-token")
+		}
+		if verifyTarget == "" {
+			return nil, fmt.Errorf("verify-connection=true requires --cluster-name or --name")
+		}
+	}
+
 	if detectNetworkKey && networkKey == "" {
 		if kubeConfig == nil {
 			return nil, fmt.Errorf("detect-network-key=true requires --kube-config")
@@ -278,6 +318,15 @@ func (m *Argocd) BootstrapClusterbookCluster(
 		}
 	}
 
+	if verifyConnection {
+		if _, err := m.VerifyClusterConnection(
+			ctx, argocdServer, verifyTarget, argocdAuthToken, "", nil,
+			argocdInsecure, true, verifyTimeout, "10s", cacheBuster,
+		); err != nil {
+			return nil, fmt.Errorf("verify-connection: %w", err)
+		}
+	}
+
 	if commitToGit {
 		if repository == "" {
 			return nil, fmt.Errorf("commit-to-git=true requires --repository")
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger/argocd/internal/dagger"
+
+	"gopkg.in/yaml.v3"
+)
+
+// clusterInventory is the YAML inventory consumed by
+// BootstrapClusterbookClusters.
+type clusterInventory struct {
+	Clusters []inventoryCluster `yaml:"clusters"`
+}
+
+// inventoryCluster is one cluster entry of a registration wave.
+type inventoryCluster struct {
+	Name        string `yaml:"name"`
+	ClusterName string `yaml:"clusterName,omitempty"`
+	NetworkKey  string `yaml:"networkKey,omitempty"`
+	// DetectNetworkKey derives networkKey from the nodes of the cluster
+	// behind KubeconfigFile when networkKey is empty.
+	DetectNetworkKey bool              `yaml:"detectNetworkKey,omitempty"`
+	Labels           map[string]string `yaml:"labels,omitempty"`
+	// KubeconfigFile is the SOPS-encrypted kubeconfig of the cluster,
+	// relative to inventoryDir.
+	KubeconfigFile string `yaml:"kubeconfigFile,omitempty"`
+}
+
+// bulkClusterResult is one row of the BootstrapClusterbookClusters result.
+type bulkClusterResult struct {
+	Name       string   `json:"name"`
+	Status     string   `json:"status"` // ok | failed
+	NetworkKey string   `json:"networkKey,omitempty"`
+	Files      []string `json:"files,omitempty"`
+	Deployed   bool     `json:"deployed"`
+	DurationMs int64    `json:"durationMs"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// bulkResult is the JSON document returned by BootstrapClusterbookClusters.
+type bulkResult struct {
+	Status    string              `json:"status"` // ok | partial | failed
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	Clusters  []bulkClusterResult `json:"clusters"`
+	Commit    string              `json:"commit,omitempty"`
+	CommitErr string              `json:"commitError,omitempty"`
+}
+
+// BootstrapClusterbookClusters registers a wave of clusters from a YAML
+// inventory: per cluster optional network-key detection → render →
+// optional kubeconfig Secret → optional deploy, then ONE commit (and at
+// most one PR) containing the files of every cluster that succeeded.
+//
+// Inventory format:
+//
+//	clusters:
+//	  - name: philly
+//	    networkKey: 10.31.101
+//	    labels: {env: lab, role: mgmt}
+//	    kubeconfigFile: kubeconfigs/philly.yaml   # SOPS, relative to --inventory-dir
+//	  - name: boston
+//	    detectNetworkKey: true                    # needs kubeconfigFile
+//	    kubeconfigFile: kubeconfigs/boston.yaml
+//
+// Files are committed per cluster as <destinationPath>/<name>/<fileName>
+// and <kubeconfigFileName>, the layout BootstrapClusterbookCluster and
+// DeregisterClusterbookCluster use with destinationPath=<...>/<name>/.
+// A failing cluster does not stop the others; the
+// returned JSON lists per-cluster status, and the commit result.
+func (m *Argocd) BootstrapClusterbookClusters(
+	ctx context.Context,
+	// YAML inventory file
+	inventory *dagger.File,
+	// Directory kubeconfigFile paths are resolved against
+	// +optional
+	inventoryDir *dagger.Directory,
+	// Maximum number of clusters processed in parallel
+	// +optional
+	// +default=4
+	concurrency int,
+	// OCI KCL module source
+	// +optional
+	// +default="ghcr.io/stuttgart-things/clusterbook-cluster-gen:0.3.0"
+	ociSource string,
+	// +optional
+	// +default="main.k"
+	entrypoint string,
+	// +optional
+	argocdNamespace string,
+	// +optional
+	kubeconfigSecretNamespace string,
+	// +optional
+	providerConfigName string,
+
+	// --- Deploy step (optional) ---
+
+	// Apply the rendered configs to the Argo CD cluster
+	// +optional
+	// +default=false
+	deploy bool,
+	// Kubeconfig of the Argo CD cluster — required when deploy=true
+	// +optional
+	kubeConfig *dagger.Secret,
+	// Target namespace for apply
+	// +optional
+	// +default="argocd"
+	deployNamespace string,
+
+	// --- Kubeconfig Secret step (optional) ---
+
+	// Render a v1/Secret from each cluster's kubeconfigFile
+	// +optional
+	// +default=false
+	renderKubeconfigSecret bool,
+	// AGE private key for decrypting kubeconfigFile entries
+	// +optional
+	sopsKey *dagger.Secret,
+	// AGE public key for re-encrypting the rendered Secrets // pragma: allowlist secret
+	// +optional
+	agePublicKey *dagger.Secret,
+	// SOPS config file (.sops.yaml) used during re-encryption
+	// +optional
+	sopsConfigFile *dagger.File,
+	// Data key under data: in the rendered Secrets
+	// +optional
+	// +default="kubeconfig"
+	kubeconfigDataKey string,
+
+	// --- Commit step (optional) ---
+
+	// Commit all rendered files in one commit
+	// +optional
+	// +default=false
+	commitToGit bool,
+	// +optional
+	repository string,
+	// +optional
+	gitToken *dagger.Secret,
+	// +optional
+	// +default="main"
+	branchName string,
+	// Parent folder; each cluster's files go to <destinationPath>/<name>/
+	// +optional
+	// +default="argocd/clusters/"
+	destinationPath string,
+	// Cluster config file name within each cluster folder
+	// +optional
+	// +default="cluster.yaml"
+	fileName string,
+	// Kubeconfig Secret file name within each cluster folder
+	// +optional
+	// +default="kubeconfig.yaml"
+	kubeconfigFileName string,
+	// Commit message (defaults to a message listing the clusters)
+	// +optional
+	commitMessage string,
+	// +optional
+	// +default=false
+	createPR bool,
+	// +optional
+	// +default="main"
+	baseBranch string,
+	// +optional
+	prTitle string,
+	// +optional
+	prBody string,
+	// +optional
+	// +default=false
+	mergePR bool,
+	// squash | merge | rebase
+	// +optional
+	// +default="squash"
+	mergeMethod string,
+
+	// --- Cache control ---
+
+	// Arbitrary string mixed into the function-level cache key (see
+	// bootstrap-clusterbook-cluster).
+	// +optional
+	cacheBuster string,
+) (string, error) {
+	_ = cacheBuster
+	if inventory == nil {
+		return "", fmt.Errorf("inventory is required")
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if deploy && kubeConfig == nil {
+		return "", fmt.Errorf("deploy=true requires --kube-config")
+	}
+	if commitToGit && (repository == "" || gitToken == nil) {
+		return "", fmt.Errorf("commit-to-git=true requires --repository and --git-token")
+	}
+	if renderKubeconfigSecret && sopsKey == nil {
+		return "", fmt.Errorf("render-kubeconfig-secret=true requires --sops-key") // pragma: allowlist secret
+	}
+	if renderKubeconfigSecret && commitToGit && agePublicKey == nil {
+		return "", fmt.Errorf("render-kubeconfig-secret=true with commit-to-git=true requires --age-public-key") // pragma: allowlist secret
+	}
+	if kubeconfigSecretNamespace == "" {
+		kubeconfigSecretNamespace = "argocd" // pragma: allowlist secret
+	}
+
+	raw, err := inventory.Contents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("read inventory: %w", err)
+	}
+	var inv clusterInventory
+	if err := yaml.Unmarshal([]byte(raw), &inv); err != nil {
+		return "", fmt.Errorf("parse inventory: %w", err)
+	}
+	if len(inv.Clusters) == 0 {
+		return "", fmt.Errorf("inventory has no clusters")
+	}
+	seen := map[string]bool{}
+	for i, c := range inv.Clusters {
+		switch {
+		case c.Name == "":
+			return "", fmt.Errorf("cluster %d has no name", i)
+		case seen[c.Name]:
+			return "", fmt.Errorf("duplicate cluster name %q", c.Name)
+		case c.NetworkKey == "" && !c.DetectNetworkKey:
+			return "", fmt.Errorf("cluster %q needs networkKey or detectNetworkKey: true", c.Name)
+		case c.NetworkKey == "" && c.KubeconfigFile == "":
+			return "", fmt.Errorf("cluster %q: detectNetworkKey needs kubeconfigFile", c.Name)
+		case c.KubeconfigFile != "" && (inventoryDir == nil || sopsKey == nil):
+			return "", fmt.Errorf("cluster %q uses kubeconfigFile but --inventory-dir or --sops-key is not set", c.Name)
+		}
+		seen[c.Name] = true
+	}
+
+	results := make([]bulkClusterResult, len(inv.Clusters))
+	files := make([]map[string]*dagger.File, len(inv.Clusters))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, c := range inv.Clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			res := bulkClusterResult{Name: c.Name, Status: "ok"}
+			out, err := m.bootstrapInventoryCluster(ctx, c, inventoryDir, &res,
+				ociSource, entrypoint, argocdNamespace, kubeconfigSecretNamespace, providerConfigName,
+				deploy, kubeConfig, deployNamespace,
+				renderKubeconfigSecret, sopsKey, agePublicKey, sopsConfigFile, kubeconfigDataKey, commitToGit,
+				fileName, kubeconfigFileName)
+			if err != nil {
+				res.Status, res.Error = "failed", err.Error()
+			} else {
+				files[i] = out
+			}
+			res.DurationMs = time.Since(start).Milliseconds()
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+
+	result := bulkResult{Clusters: results}
+	var registered []string
+	commitDir := dag.Directory()
+	for i, r := range results {
+		if r.Status != "ok" {
+			result.Failed++
+			continue
+		}
+		result.Succeeded++
+		registered = append(registered, r.Name)
+		names := make([]string, 0, len(files[i]))
+		for name := range files[i] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			commitDir = commitDir.WithFile(name, files[i][name])
+		}
+	}
+	switch {
+	case result.Failed == 0:
+		result.Status = "ok"
+	case result.Succeeded == 0:
+		result.Status = "failed"
+	default:
+		result.Status = "partial"
+	}
+
+	if commitToGit && len(registered) > 0 {
+		if commitMessage == "" {
+			commitMessage = "Register Argo CD clusters: " + strings.Join(registered, ", ")
+		}
+		if prBody == "" {
+			prBody = "Adds clusterbook-rendered registration manifests for: " + strings.Join(registered, ", ")
+		}
+		msg, err := m.CommitFiles(
+			ctx, commitDir, repository, gitToken,
+			branchName, destinationPath, commitMessage,
+			createPR, baseBranch, prTitle, prBody,
+			mergePR, mergeMethod,
+		)
+		result.Commit = msg
+		if err != nil {
+			result.CommitErr = err.Error()
+			result.Status = "failed"
+		}
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal result: %w", err)
+	}
+	return string(data), nil
+}
+
+// bootstrapInventoryCluster runs detect → render → kubeconfig Secret →
+// deploy for one inventory entry and returns the files to commit, keyed
+// by <name>/<file name>.
+func (m *Argocd) bootstrapInventoryCluster(
+	ctx context.Context,
+	c inventoryCluster,
+	inventoryDir *dagger.Directory,
+	res *bulkClusterResult,
+	ociSource, entrypoint, argocdNamespace, kubeconfigSecretNamespace, providerConfigName string,
+	deploy bool,
+	kubeConfig *dagger.Secret,
+	deployNamespace string,
+	renderKubeconfigSecret bool,
+	sopsKey, agePublicKey *dagger.Secret,
+	sopsConfigFile *dagger.File,
+	kubeconfigDataKey string,
+	commitToGit bool,
+	fileName, kubeconfigFileName string,
+) (map[string]*dagger.File, error) {
+	if fileName == "" {
+		fileName = "cluster.yaml"
+	}
+	if kubeconfigFileName == "" { // pragma: allowlist secret
+		kubeconfigFileName = "kubeconfig.yaml" // pragma: allowlist secret
+	}
+	var kubeconfigSource *dagger.File
+	if c.KubeconfigFile != "" {
+		kubeconfigSource = inventoryDir.File(c.KubeconfigFile)
+	}
+
+	networkKey := c.NetworkKey
+	if networkKey == "" {
+		plaintext, err := dag.Secrets().Decrypt(ctx, sopsKey, kubeconfigSource)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt %s: %w", c.KubeconfigFile, err)
+		}
+		detected, err := m.DetectNetworkKey(ctx, dag.SetSecret("bulk-kubeconfig-"+c.Name, plaintext))
+		if err != nil {
+			return nil, fmt.Errorf("detect-network-key: %w", err)
+		}
+		networkKey = detected
+	}
+	res.NetworkKey = networkKey
+
+	labels := ""
+	if len(c.Labels) > 0 {
+		data, err := json.Marshal(c.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("labels: %w", err)
+		}
+		labels = string(data)
+	}
+
+	rendered, err := m.RenderClusterbookClusterConfig(
+		ctx,
+		c.Name, networkKey, nil, ociSource, c.ClusterName,
+		nil, nil, nil,
+		"", kubeconfigSecretNamespace,
+		argocdNamespace, providerConfigName, labels, entrypoint,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("render: %w", err)
+	}
+	// Materialize the render before anything touches the cluster or git
+	// (see BootstrapClusterbookCluster).
+	if rendered, err = rendered.Sync(ctx); err != nil {
+		return nil, fmt.Errorf("render: %w", err)
+	}
+
+	files := map[string]*dagger.File{c.Name + "/" + fileName: rendered}
+
+	var plaintextSecret *dagger.File
+	if renderKubeconfigSecret && kubeconfigSource != nil {
+		if commitToGit {
+			encrypted, err := m.RenderKubeconfigSecret(
+				ctx, kubeconfigSource, sopsKey, c.Name, kubeconfigSecretNamespace,
+				kubeconfigDataKey, true, agePublicKey, sopsConfigFile,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("render-kubeconfig-secret (encrypted): %w", err)
+			}
+			files[c.Name+"/"+kubeconfigFileName] = encrypted
+		}
+		if deploy {
+			plaintextSecret, err = m.RenderKubeconfigSecret(
+				ctx, kubeconfigSource, sopsKey, c.Name, kubeconfigSecretNamespace,
+				kubeconfigDataKey, false, nil, nil,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("render-kubeconfig-secret (plaintext): %w", err)
+			}
+		}
+	}
+
+	if deploy {
+		if _, err := m.ApplyConfig(ctx, rendered, kubeConfig, deployNamespace); err != nil {
+			return nil, fmt.Errorf("deploy: %w", err)
+		}
+		if plaintextSecret != nil { // pragma: allowlist secret
+			if _, err := m.ApplyConfig(ctx, plaintextSecret, kubeConfig, kubeconfigSecretNamespace); err != nil {
+				return nil, fmt.Errorf("deploy kubeconfig secret: %w", err)
+			}
+		}
+		res.Deployed = true
+	}
+
+	for name := range files {
+		res.Files = append(res.Files, name)
+	}
+	sort.Strings(res.Files)
+	return files, nil
+}
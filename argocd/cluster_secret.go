@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/argocd/internal/dagger"
+
+	"gopkg.in/yaml.v3"
+)
+
+// argocdClusterConfig is the `config` JSON of an Argo CD cluster Secret.
+type argocdClusterConfig struct {
+	BearerToken     string `json:"bearerToken"`
+	TLSClientConfig struct {
+		Insecure bool   `json:"insecure"`
+		CAData   string `json:"caData,omitempty"`
+	} `json:"tlsClientConfig"`
+}
+
+// RenderClusterSecret is the alternative to RenderKubeconfigSecret that
+// does not hand Argo CD an admin kubeconfig: it creates a dedicated,
+// revocable identity on the target cluster and renders a native Argo CD
+// cluster Secret for it.
+//
+// On the target cluster (using the SOPS-encrypted admin kubeconfig once):
+//
+//   - `ServiceAccount/<serviceAccountName>` in serviceAccountNamespace,
+//   - a long-lived SA-token `Secret/<serviceAccountName>-token`,
+//   - `ClusterRole/<serviceAccountName>-discovery` (the API discovery
+//     endpoints only), the sole default cluster-wide binding,
+//   - with namespaces set: `ClusterRole/<serviceAccountName>-read`
+//     (get/list/watch on every resource) and
+//     `ClusterRole/<serviceAccountName>` with the write rules (--rules, a
+//     JSON array of RBAC PolicyRules; default: every verb on every
+//     resource), both bound by RoleBindings in those namespaces only;
+//     Argo CD is then restricted to them and clusterResources=false,
+//   - without namespaces: `ClusterRole/<serviceAccountName>` with the
+//     required --rules, bound cluster-wide. Nothing else is readable
+//     cluster-wide, Secrets included, unless --rules grant it.
+//
+// The rendered Secret (label argocd.argoproj.io/secret-type=cluster)
+// carries name, server and a config JSON with bearerToken and
+// tlsClientConfig.caData; the CA comes from the kubeconfig, falling back
+// to the SA-token Secret's ca.crt. Without a CA the function fails unless
+// --insecure=true. The Secret is SOPS-encrypted by default for commit;
+// pass --encrypt=false for direct apply.
+//
+// Re-running is idempotent and keeps the existing token.
+func (m *Argocd) RenderClusterSecret(
+	ctx context.Context,
+	// SOPS-encrypted admin kubeconfig of the target cluster
+	kubeconfigSourceFile *dagger.File,
+	// AGE private key for decrypting kubeconfigSourceFile
+	sopsKey *dagger.Secret,
+	// Argo CD-side cluster name (also the rendered Secret name)
+	name string,
+	// API server URL Argo CD uses (defaults to the kubeconfig's server)
+	// +optional
+	server string,
+	// Namespace of the rendered Secret (the Argo CD namespace)
+	// +optional
+	// +default="argocd"
+	namespace string,
+	// JSON object literal with extra cluster labels, e.g. {"env":"lab"}
+	// +optional
+	clusterLabels string,
+	// ServiceAccount created on the target cluster
+	// +optional
+	// +default="argocd-manager"
+	serviceAccountName string,
+	// +optional
+	// +default="kube-system"
+	serviceAccountNamespace string,
+	// Comma-separated namespaces Argo CD may manage (empty: cluster-wide)
+	// +optional
+	namespaces string,
+	// JSON array of RBAC PolicyRules, e.g.
+	// [{"apiGroups":["apps"],"resources":["deployments"],"verbs":["*"]}]
+	// (empty: every verb on every resource in namespaces; required without)
+	// +optional
+	rules string,
+	// Skip TLS verification when no CA is available (not recommended)
+	// +optional
+	// +default=false
+	insecure bool,
+	// Re-encrypt the rendered Secret with SOPS using agePublicKey
+	// +optional
+	// +default=true
+	encrypt bool,
+	// AGE public key for SOPS re-encryption (required when encrypt=true)
+	// +optional
+	agePublicKey *dagger.Secret,
+	// SOPS config file (.sops.yaml) used during re-encryption
+	// +optional
+	sopsConfig *dagger.File,
+	// Arbitrary string mixed into the function-level cache key. Pass a
+	// timestamp (e.g. `date +%s%N`) to force a fresh execution: a cached
+	// result would skip the kubectl apply and return a stale token.
+	// +optional
+	cacheBuster string,
+) (*dagger.File, error) {
+	_ = cacheBuster
+	if kubeconfigSourceFile == nil {
+		return nil, fmt.Errorf("kubeconfig-source-file is required")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if encrypt && agePublicKey == nil {
+		return nil, fmt.Errorf("encrypt=true requires --age-public-key")
+	}
+	if serviceAccountName == "" {
+		serviceAccountName = "argocd-manager"
+	}
+	if serviceAccountNamespace == "" {
+		serviceAccountNamespace = "kube-system"
+	}
+	var writeRules []any
+	if rules != "" {
+		if err := json.Unmarshal([]byte(rules), &writeRules); err != nil {
+			return nil, fmt.Errorf("rules: expected a JSON array of PolicyRules: %w", err)
+		}
+		if len(writeRules) == 0 {
+			return nil, fmt.Errorf("rules: expected at least one PolicyRule")
+		}
+	}
+	if len(writeRules) == 0 && len(splitList(namespaces)) == 0 {
+		return nil, fmt.Errorf("cluster-wide management requires explicit --rules; pass --namespaces to use the namespaced defaults")
+	}
+	var labels map[string]string
+	if clusterLabels != "" {
+		if err := json.Unmarshal([]byte(clusterLabels), &labels); err != nil {
+			return nil, fmt.Errorf("cluster-labels: expected a JSON object of strings: %w", err)
+		}
+	}
+
+	kubeconfigYaml, err := dag.Secrets().Decrypt(ctx, sopsKey, kubeconfigSourceFile)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt kubeconfig: %w", err)
+	}
+	var kc kubeconfigShape
+	if err := yaml.Unmarshal([]byte(kubeconfigYaml), &kc); err != nil {
+		return nil, fmt.Errorf("parse kubeconfig: %w", err)
+	}
+	if len(kc.Clusters) == 0 {
+		return nil, fmt.Errorf("kubeconfig has no clusters")
+	}
+	if server == "" {
+		server = kc.Clusters[0].Cluster.Server
+	}
+	if server == "" {
+		return nil, fmt.Errorf("kubeconfig has no clusters[0].cluster.server; pass --server")
+	}
+	kubeconfigSecret := dag.SetSecret("cluster-secret-kubeconfig-"+name, kubeconfigYaml)
+
+	// Phase 1: ServiceAccount, token Secret and RBAC on the target cluster.
+	rbac, err := buildServiceAccountManifests(serviceAccountName, serviceAccountNamespace, splitList(namespaces), writeRules)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dag.Kubernetes().Kubectl(ctx, dagger.KubernetesKubectlOpts{
+		Operation: "apply",
+		SourceFile: dag.Directory().
+			WithNewFile("argocd-manager.yaml", rbac).
+			File("argocd-manager.yaml"),
+		KubeConfig: kubeconfigSecret,
+		ServerSide: true,
+	}); err != nil {
+		return nil, fmt.Errorf("kubectl apply: %w", err)
+	}
+
+	// Phase 2: wait for the token controller, then read token + CA via
+	// files so they never appear in the exec output.
+	token, ca, err := readServiceAccountToken(ctx, kubeconfigSecret, serviceAccountNamespace, serviceAccountName+"-token")
+	if err != nil {
+		return nil, fmt.Errorf("read service account token: %w", err)
+	}
+	if kc.Clusters[0].Cluster.CertificateAuthorityData != "" {
+		ca = kc.Clusters[0].Cluster.CertificateAuthorityData
+	}
+
+	// Phase 3: render (and encrypt) the Argo CD cluster Secret.
+	manifest, err := buildArgocdClusterSecret(name, namespace, server, token, ca, insecure, labels, splitList(namespaces))
+	if err != nil {
+		return nil, err
+	}
+	plainFile := dag.Directory().
+		WithNewFile("cluster-secret.yaml", manifest).
+		File("cluster-secret.yaml")
+
+	if !encrypt {
+		return plainFile, nil
+	}
+
+	encrypted, err := dag.Secrets().EncryptFile(
+		ctx,
+		agePublicKey,
+		plainFile,
+		dagger.SecretsEncryptFileOpts{
+			FileExtension: "yaml",
+			SopsConfig:    sopsConfig,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt manifest: %w", err)
+	}
+
+	return dag.Directory().
+		WithNewFile("cluster-secret.enc.yaml", encrypted).
+		File("cluster-secret.enc.yaml"), nil
+}
+
+// readServiceAccountToken waits up to 60s for the SA-token Secret to be
+// populated and returns the decoded token and the base64 ca.crt.
+func readServiceAccountToken(ctx context.Context, kubeConfig *dagger.Secret, namespace, secretName string) (string, string, error) {
+	script := fmt.Sprintf(`set -eu
+export KUBECONFIG=/work/kubeconfig
+mkdir -p /out
+for i in $(seq 1 30); do
+  kubectl -n %[1]s get secret %[2]s -o jsonpath='{.data.token}' > /out/token 2>/dev/null || true
+  kubectl -n %[1]s get secret %[2]s -o jsonpath='{.data.ca\.crt}' > /out/ca 2>/dev/null || true
+  if [ -s /out/token ] && [ -s /out/ca ]; then echo "token ready"; exit 0; fi
+  sleep 2
+done
+echo "SA-token Secret %[1]s/%[2]s never populated after 60s" >&2
+exit 1
+`, namespace, secretName)
+
+	ctr := dag.Container().
+		From("alpine/k8s:1.31.0").
+		WithMountedSecret("/work/kubeconfig", kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", script})
+
+	encodedToken, err := ctr.File("/out/token").Contents(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	ca, err := ctr.File("/out/ca").Contents(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	token, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedToken))
+	if err != nil {
+		return "", "", fmt.Errorf("decode token: %w", err)
+	}
+	return string(token), strings.TrimSpace(ca), nil
+}
+
+// discoveryRules are the only permissions granted cluster-wide by
+// default: the API discovery endpoints Argo CD needs to build its cache.
+var discoveryRules = []any{
+	map[string]any{"nonResourceURLs": []string{"/version", "/api", "/api/*", "/apis", "/apis/*"}, "verbs": []string{"get"}},
+}
+
+// namespaceReadRules let the Argo CD identity watch every resource in the
+// namespaces it manages; they are only ever bound per namespace.
+var namespaceReadRules = []any{
+	map[string]any{"apiGroups": []string{"*"}, "resources": []string{"*"}, "verbs": []string{"get", "list", "watch"}},
+}
+
+// defaultWriteRules is used for namespaced management when no --rules are
+// given.
+var defaultWriteRules = []any{
+	map[string]any{"apiGroups": []string{"*"}, "resources": []string{"*"}, "verbs": []string{"*"}},
+}
+
+// buildServiceAccountManifests renders the ServiceAccount, its long-lived
+// token Secret and its RBAC. Only the discovery role is bound cluster-wide.
+// With namespaces set, the read role and the write role (writeRules,
+// default defaultWriteRules) are bound per namespace through RoleBindings.
+// Without namespaces, writeRules are required and are the only resource
+// permissions, bound cluster-wide, so nothing (Secrets included) is
+// readable cluster-wide unless the caller grants it.
+func buildServiceAccountManifests(saName, saNamespace string, namespaces []string, writeRules []any) (string, error) {
+	if len(writeRules) == 0 {
+		if len(namespaces) == 0 {
+			return "", fmt.Errorf("cluster-wide management requires explicit --rules; pass --namespaces to use the namespaced defaults")
+		}
+		writeRules = defaultWriteRules
+	}
+	subjects := []any{
+		map[string]any{"kind": "ServiceAccount", "name": saName, "namespace": saNamespace},
+	}
+	roleRef := func(name string) map[string]any {
+		return map[string]any{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     "ClusterRole",
+			"name":     name,
+		}
+	}
+
+	docs := []any{
+		map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   map[string]any{"name": saName, "namespace": saNamespace},
+		},
+		map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]any{
+				"name":      saName + "-token",
+				"namespace": saNamespace,
+				"annotations": map[string]any{
+					"kubernetes.io/service-account.name": saName,
+				},
+			},
+			"type": "kubernetes.io/service-account-token", // pragma: allowlist secret
+		},
+		map[string]any{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRole",
+			"metadata":   map[string]any{"name": saName + "-discovery"},
+			"rules":      discoveryRules,
+		},
+		map[string]any{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   map[string]any{"name": saName + "-discovery"},
+			"roleRef":    roleRef(saName + "-discovery"),
+			"subjects":   subjects,
+		},
+		map[string]any{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRole",
+			"metadata":   map[string]any{"name": saName},
+			"rules":      writeRules,
+		},
+	}
+	if len(namespaces) == 0 {
+		docs = append(docs, map[string]any{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRoleBinding",
+			"metadata":   map[string]any{"name": saName},
+			"roleRef":    roleRef(saName),
+			"subjects":   subjects,
+		})
+	}
+	if len(namespaces) > 0 {
+		docs = append(docs, map[string]any{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "ClusterRole",
+			"metadata":   map[string]any{"name": saName + "-read"},
+			"rules":      namespaceReadRules,
+		})
+	}
+	for _, ns := range namespaces {
+		for _, role := range []string{saName + "-read", saName} {
+			docs = append(docs, map[string]any{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "RoleBinding",
+				"metadata":   map[string]any{"name": role, "namespace": ns},
+				"roleRef":    roleRef(role),
+				"subjects":   subjects,
+			})
+		}
+	}
+
+	var out []string
+	for _, d := range docs {
+		doc, err := encodeYAML(d)
+		if err != nil {
+			return "", fmt.Errorf("marshal service account manifests: %w", err)
+		}
+		out = append(out, doc)
+	}
+	return strings.Join(out, "---\n"), nil
+}
+
+// buildArgocdClusterSecret renders the declarative Argo CD cluster Secret.
+// caData is the base64-encoded PEM, as in a kubeconfig; it is required
+// unless insecure is set.
+func buildArgocdClusterSecret(name, namespace, server, token, caData string, insecure bool, labels map[string]string, namespaces []string) (string, error) {
+	if namespace == "" {
+		namespace = "argocd"
+	}
+	if caData == "" && !insecure {
+		return "", fmt.Errorf("no CA for %s in the kubeconfig or the SA-token Secret; pass --insecure=true to skip TLS verification", server)
+	}
+	cfg := argocdClusterConfig{BearerToken: token}
+	cfg.TLSClientConfig.CAData = caData
+	cfg.TLSClientConfig.Insecure = caData == ""
+	config, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("marshal cluster config: %w", err)
+	}
+
+	allLabels := map[string]string{}
+	for k, v := range labels {
+		allLabels[k] = v
+	}
+	allLabels["argocd.argoproj.io/secret-type"] = "cluster" // pragma: allowlist secret
+
+	stringData := map[string]string{
+		"name":   name,
+		"server": server,
+		"config": string(config),
+	}
+	if len(namespaces) > 0 {
+		stringData["namespaces"] = strings.Join(namespaces, ",")
+		stringData["clusterResources"] = "false"
+	}
+
+	manifest, err := encodeYAML(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":      name,
+			"namespace": namespace,
+			"labels":    allLabels,
+		},
+		"type":       "Opaque",
+		"stringData": stringData,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal cluster secret: %w", err)
+	}
+	return manifest, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -263,24 +263,11 @@ func (m *Argocd) CommitFiles(
 	var results []string
 
 	if branchName != baseBranch {
-		exists, err := branchExists(ctx, repository, branchName, gitToken)
+		msg, err := ensureBranch(ctx, repository, branchName, baseBranch, gitToken)
 		if err != nil {
-			return "", fmt.Errorf("ensure-branch (probe): %w", err)
-		}
-		if exists {
-			results = append(results, fmt.Sprintf("Branch %s already exists; committing on top", branchName))
-		} else {
-			if _, err := dag.Git().CreateGithubBranch(
-				ctx,
-				repository,
-				branchName,
-				gitToken,
-				dagger.GitCreateGithubBranchOpts{BaseBranch: baseBranch},
-			); err != nil {
-				return "", fmt.Errorf("ensure-branch: %w", err)
-			}
-			results = append(results, fmt.Sprintf("Created branch %s from %s", branchName, baseBranch))
+			return "", err
 		}
+		results = append(results, msg)
 	}
 
 	commitSHA, err := dag.Git().AddFolderToGithubBranch(
@@ -314,6 +301,61 @@ func (m *Argocd) CommitFiles(
 		prTitle = commitMessage
 	}
 
+	return openAndMergePR(ctx, results, repository, branchName, baseBranch, prTitle, prBody, gitToken, mergePR, mergeMethod)
+}
+
+// ensureBranch creates <branchName> from <baseBranch> unless it already
+// exists. dag.Git().CreateGithubBranch force-resets an existing branch
+// (see CommitConfig), so the branch is probed first and left untouched
+// when present. Returns a one-line summary for the caller's result list.
+func ensureBranch(
+	ctx context.Context,
+	repository string,
+	branchName string,
+	baseBranch string,
+	gitToken *dagger.Secret,
+) (string, error) {
+	exists, err := branchExists(ctx, repository, branchName, gitToken)
+	if err != nil {
+		return "", fmt.Errorf("ensure-branch (probe): %w", err)
+	}
+	if exists {
+		return fmt.Sprintf("Branch %s already exists; committing on top", branchName), nil
+	}
+	if _, err := dag.Git().CreateGithubBranch(
+		ctx,
+		repository,
+		branchName,
+		gitToken,
+		dagger.GitCreateGithubBranchOpts{BaseBranch: baseBranch},
+	); err != nil {
+		return "", fmt.Errorf("ensure-branch: %w", err)
+	}
+	return fmt.Sprintf("Created branch %s from %s", branchName, baseBranch), nil
+}
+
+// openAndMergePR opens a PR from <branchName> into <baseBranch> (an
+// already open PR is reused) and, with mergePR, merges it. Summary lines
+// are appended to results, which is returned joined.
+func openAndMergePR(
+	ctx context.Context,
+	results []string,
+	repository string,
+	branchName string,
+	baseBranch string,
+	prTitle string,
+	prBody string,
+	gitToken *dagger.Secret,
+	mergePR bool,
+	mergeMethod string,
+) (string, error) {
+	if branchName == baseBranch {
+		return "", fmt.Errorf("create-pr: branchName (%q) must differ from baseBranch (%q)", branchName, baseBranch)
+	}
+
+	// gh pr merge accepts the PR URL or its head branch; the branch is
+	// used when the PR already existed and no URL came back.
+	prRef := branchName
 	prURL, err := dag.Git().CreateGithubPullRequest(
 		ctx,
 		repository,
@@ -333,7 +375,8 @@ func (m *Argocd) CommitFiles(
 			return "", fmt.Errorf("create-pr: %w", err)
 		}
 	} else {
-		results = append(results, fmt.Sprintf("Opened PR: %s", prURL))
+		prRef = strings.TrimSpace(prURL)
+		results = append(results, fmt.Sprintf("Opened PR: %s", prRef))
 	}
 
 	if !mergePR {
@@ -355,7 +398,8 @@ func (m *Argocd) CommitFiles(
 		}).
 		WithSecretVariable("GH_TOKEN", gitToken).
 		WithExec([]string{
-			"gh", "pr", "merge", strings.TrimSpace(prURL),
+			"gh", "pr", "merge", prRef,
+			"--repo", repository,
 			"--" + method,
 			"--delete-branch",
 		}).
@@ -6,26 +6,67 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
 
 	"dagger/argocd/internal/dagger"
+	"dagger/argocd/internal/vault"
 
 	"gopkg.in/yaml.v3"
 )
 
 // vaultEnv is the decoded shape of the SOPS-encrypted vault env yaml the
 // caller provides via --vault-env-file. Only `vaultAddr` + `vaultToken`
-// are mandatory; `vaultSkipVerify` defaults to true. `vaultCaBundle`,
-// when set, is the base64-encoded PKI root CA PEM and short-circuits the
-// live fetch from `${vaultAddr}/v1/pki/ca/pem` (useful when the CA is
-// known and stable).
+// are mandatory. The Vault server certificate is verified against
+// `vaultCaCert` (PEM or base64-encoded PEM) when set, else the system
+// roots; `vaultSkipVerify: true` turns verification off. `vaultNamespace`
+// selects a Vault Enterprise namespace. `vaultCaBundle`, when set, is the
+// base64-encoded PKI root CA PEM and short-circuits the live fetch from
+// `${vaultAddr}/v1/pki/ca/pem` (useful when the CA is known and stable).
 type vaultEnv struct {
 	VaultAddr       string `yaml:"vaultAddr"`
 	VaultToken      string `yaml:"vaultToken"`
-	VaultSkipVerify *bool  `yaml:"vaultSkipVerify,omitempty"`
+	VaultNamespace  string `yaml:"vaultNamespace,omitempty"`
+	VaultCaCert     string `yaml:"vaultCaCert,omitempty"`
+	VaultSkipVerify bool   `yaml:"vaultSkipVerify,omitempty"`
 	VaultCaBundle   string `yaml:"vaultCaBundle,omitempty"`
 }
 
+// decryptVaultEnv decrypts and validates the vault env yaml shared by the
+// Vault functions and returns it with a client for the Vault server.
+func decryptVaultEnv(ctx context.Context, sopsKey *dagger.Secret, vaultEnvFile *dagger.File) (vaultEnv, *vault.Client, error) {
+	var env vaultEnv
+	envYaml, err := dag.Secrets().Decrypt(ctx, sopsKey, vaultEnvFile)
+	if err != nil {
+		return env, nil, fmt.Errorf("decrypt vault-env-file: %w", err)
+	}
+	if err := yaml.Unmarshal([]byte(envYaml), &env); err != nil {
+		return env, nil, fmt.Errorf("parse vault-env-file as yaml: %w", err)
+	}
+	if env.VaultAddr == "" {
+		return env, nil, fmt.Errorf("vault-env-file is missing vaultAddr")
+	}
+	if env.VaultToken == "" {
+		return env, nil, fmt.Errorf("vault-env-file is missing vaultToken")
+	}
+
+	caCert := []byte(strings.TrimSpace(env.VaultCaCert))
+	if len(caCert) > 0 && !strings.HasPrefix(string(caCert), "-----BEGIN") {
+		if caCert, err = base64.StdEncoding.DecodeString(string(caCert)); err != nil {
+			return env, nil, fmt.Errorf("vault-env-file: vaultCaCert is neither PEM nor base64: %w", err)
+		}
+	}
+	client, err := vault.New(vault.Config{
+		Address:            env.VaultAddr,
+		Token:              env.VaultToken,
+		Namespace:          env.VaultNamespace,
+		CACert:             caCert,
+		InsecureSkipVerify: env.VaultSkipVerify,
+	})
+	if err != nil {
+		return env, nil, fmt.Errorf("vault-env-file: %w", err)
+	}
+	return env, client, nil
+}
+
 // vaultPolicyHCL is the ACL policy applied to the Vault server before
 // minting the cert-manager token. Idempotent — `PUT
 // /v1/sys/policies/acl/<name>` upserts.
@@ -67,7 +108,8 @@ func (m *Argocd) CreateVaultIssuer(
 	// SOPS-encrypted kubeconfig of the target cluster.
 	kubeconfigSourceFile *dagger.File,
 	// SOPS-encrypted KV-YAML with `vaultAddr`, `vaultToken`,
-	// optional `vaultSkipVerify` (defaults to true), and optional
+	// optional `vaultNamespace`, `vaultCaCert` (CA of the Vault server
+	// certificate), `vaultSkipVerify` (defaults to false), and optional
 	// `vaultCaBundle` (base64-encoded PKI root CA PEM; when set, the
 	// function uses it directly instead of live-fetching from
 	// `${vaultAddr}/v1/pki/ca/pem`).
@@ -124,24 +166,10 @@ func (m *Argocd) CreateVaultIssuer(
 		return "", fmt.Errorf("sops-key is required")
 	}
 
-	// Decrypt the vault env yaml and parse the connection details.
-	envYaml, err := dag.Secrets().Decrypt(ctx, sopsKey, vaultEnvFile)
+	// Decrypt the vault env yaml and connect to Vault.
+	env, vc, err := decryptVaultEnv(ctx, sopsKey, vaultEnvFile)
 	if err != nil {
-		return "", fmt.Errorf("decrypt vault-env-file: %w", err)
-	}
-	var env vaultEnv
-	if err := yaml.Unmarshal([]byte(envYaml), &env); err != nil {
-		return "", fmt.Errorf("parse vault-env-file as yaml: %w", err)
-	}
-	if env.VaultAddr == "" {
-		return "", fmt.Errorf("vault-env-file is missing vaultAddr")
-	}
-	if env.VaultToken == "" {
-		return "", fmt.Errorf("vault-env-file is missing vaultToken")
-	}
-	skipVerify := true
-	if env.VaultSkipVerify != nil {
-		skipVerify = *env.VaultSkipVerify
+		return "", err
 	}
 
 	// Decrypt the kubeconfig and wrap it as a Secret so dag.Kubernetes()
@@ -155,7 +183,7 @@ func (m *Argocd) CreateVaultIssuer(
 	// Vault-side: upsert policy, mint token. CA bundle: prefer
 	// `vaultCaBundle` from the env file when present (already
 	// base64-encoded PEM), else live-fetch via GET /v1/pki/ca/pem.
-	tokenStr, livePEM, err := m.vaultProvision(ctx, env.VaultAddr, env.VaultToken, skipVerify, policyName, clusterName, tokenTtl, env.VaultCaBundle == "")
+	tokenStr, livePEM, err := vaultProvision(ctx, vc, policyName, clusterName, tokenTtl, env.VaultCaBundle == "")
 	if err != nil {
 		return "", fmt.Errorf("vault provision: %w", err)
 	}
@@ -203,78 +231,36 @@ func (m *Argocd) CreateVaultIssuer(
 	return output, nil
 }
 
-// vaultProvision runs the Vault HTTP calls in a single alpine+curl+jq
-// container and returns (cert-manager token, PKI CA PEM). When
-// `fetchCA=false` the CA fetch is skipped and the second return value is
-// empty — the caller is expected to source the CA from the env file's
-// `vaultCaBundle`.
-func (m *Argocd) vaultProvision(
+// vaultProvision upserts the ACL policy, mints the cert-manager token and
+// reads the PKI CA, returning (token, CA PEM). When `fetchCA=false` the
+// CA fetch is skipped and the second return value is empty — the caller
+// is expected to source the CA from the env file's `vaultCaBundle`.
+func vaultProvision(
 	ctx context.Context,
-	vaultAddr, vaultToken string,
-	skipVerify bool,
+	vc *vault.Client,
 	policyName, clusterName, tokenTtl string,
 	fetchCA bool,
 ) (token string, caPEM string, err error) {
-	addr := strings.TrimRight(vaultAddr, "/")
-	curlBase := []string{"curl", "-fsS"}
-	if skipVerify {
-		curlBase = append(curlBase, "-k")
-	}
-	curlBaseStr := strings.Join(curlBase, " ")
-
-	policyPayload, err := json.Marshal(map[string]string{"policy": vaultPolicyHCL})
-	if err != nil {
-		return "", "", fmt.Errorf("marshal policy payload: %w", err)
+	if err := vc.PutPolicy(ctx, policyName, vaultPolicyHCL); err != nil {
+		return "", "", fmt.Errorf("upsert policy: %w", err)
 	}
-	tokenPayload, err := json.Marshal(map[string]any{
-		"policies":     []string{policyName},
-		"display_name": "cert-manager-" + clusterName,
-		"ttl":          tokenTtl,
-		"renewable":    true,
+	token, err = vc.CreateToken(ctx, vault.TokenRequest{
+		Policies:    []string{policyName},
+		DisplayName: "cert-manager-" + clusterName,
+		TTL:         tokenTtl,
+		Renewable:   true,
 	})
 	if err != nil {
-		return "", "", fmt.Errorf("marshal token payload: %w", err)
-	}
-
-	steps := []string{
-		"set -euo pipefail",
-		"mkdir -p /out",
-		fmt.Sprintf(`%s -X PUT -H "X-Vault-Token: ${VAULT_TOKEN}" -H "Content-Type: application/json" --data %q "%s/v1/sys/policies/acl/%s"`,
-			curlBaseStr, string(policyPayload), addr, policyName),
-		fmt.Sprintf(`%s -X POST -H "X-Vault-Token: ${VAULT_TOKEN}" -H "Content-Type: application/json" --data %q "%s/v1/auth/token/create" | jq -r .auth.client_token > /out/token`,
-			curlBaseStr, string(tokenPayload), addr),
-		`test -s /out/token`,
-	}
-	if fetchCA {
-		steps = append(steps,
-			fmt.Sprintf(`%s -H "X-Vault-Token: ${VAULT_TOKEN}" "%s/v1/pki/ca/pem" > /out/ca.pem`,
-				curlBaseStr, addr),
-			`test -s /out/ca.pem`,
-		)
-	}
-	script := strings.Join(steps, "\n")
-
-	vaultTokenSecret := dag.SetSecret("vault-issuer-admin-token", vaultToken)
-	cacheBuster := time.Now().UTC().Format(time.RFC3339Nano)
-	ctr := dag.Container().
-		From("alpine:3.21").
-		WithExec([]string{"apk", "add", "--no-cache", "curl", "jq"}).
-		WithSecretVariable("VAULT_TOKEN", vaultTokenSecret).
-		WithEnvVariable("CACHE_BUSTER", cacheBuster).
-		WithExec([]string{"sh", "-c", script})
-
-	tokenOut, err := ctr.File("/out/token").Contents(ctx)
-	if err != nil {
-		return "", "", fmt.Errorf("read minted token: %w", err)
+		return "", "", fmt.Errorf("mint token: %w", err)
 	}
 	if !fetchCA {
-		return strings.TrimSpace(tokenOut), "", nil
+		return token, "", nil
 	}
-	caOut, err := ctr.File("/out/ca.pem").Contents(ctx)
+	caPEM, err = vc.ReadPKICA(ctx, "pki")
 	if err != nil {
 		return "", "", fmt.Errorf("read CA bundle: %w", err)
 	}
-	return strings.TrimSpace(tokenOut), strings.TrimSuffix(caOut, "\n"), nil
+	return token, caPEM, nil
 }
 
 // vaultIssuerManifestTemplate is the multi-document YAML rendered into
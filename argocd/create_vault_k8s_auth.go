@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
 
 	"dagger/argocd/internal/dagger"
+	"dagger/argocd/internal/vault"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,11 +17,12 @@ import (
 // server URL out of a kubeconfig. We mount the full kubeconfig into the
 // container as a Secret; only `clusters[0].cluster.server` needs to come
 // back to the host side (it's the `kubernetes_host` value Vault stores
-// in the auth backend config).
+// in the auth backend config). The CA is used by RenderClusterSecret.
 type kubeconfigShape struct {
 	Clusters []struct {
 		Cluster struct {
-			Server string `yaml:"server"`
+			Server                   string `yaml:"server"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
 		} `yaml:"cluster"`
 	} `yaml:"clusters"`
 }
@@ -65,8 +67,8 @@ func (m *Argocd) CreateVaultKubernetesAuth(
 	clusterName string,
 	// SOPS-encrypted kubeconfig of the target cluster.
 	kubeconfigSourceFile *dagger.File,
-	// SOPS-encrypted env YAML with `vaultAddr`, `vaultToken`, optional
-	// `vaultSkipVerify` (defaults true). Same shape as create-vault-issuer.
+	// SOPS-encrypted env YAML with `vaultAddr`, `vaultToken` and the
+	// optional TLS/namespace keys. Same shape as create-vault-issuer.
 	vaultEnvFile *dagger.File,
 	// AGE private key for decrypting both files.
 	sopsKey *dagger.Secret,
@@ -120,25 +122,11 @@ func (m *Argocd) CreateVaultKubernetesAuth(
 		return "", fmt.Errorf("token-policies must be a non-empty comma-separated list")
 	}
 
-	// Decrypt the vault env yaml (reuses the vaultEnv struct defined in
-	// create_vault_issuer.go — same package, same shape).
-	envYaml, err := dag.Secrets().Decrypt(ctx, sopsKey, vaultEnvFile)
+	// Decrypt the vault env yaml (shared with create-vault-issuer) and
+	// connect to Vault.
+	_, vc, err := decryptVaultEnv(ctx, sopsKey, vaultEnvFile)
 	if err != nil {
-		return "", fmt.Errorf("decrypt vault-env-file: %w", err)
-	}
-	var env vaultEnv
-	if err := yaml.Unmarshal([]byte(envYaml), &env); err != nil {
-		return "", fmt.Errorf("parse vault-env-file as yaml: %w", err)
-	}
-	if env.VaultAddr == "" {
-		return "", fmt.Errorf("vault-env-file is missing vaultAddr")
-	}
-	if env.VaultToken == "" {
-		return "", fmt.Errorf("vault-env-file is missing vaultToken")
-	}
-	skipVerify := true
-	if env.VaultSkipVerify != nil {
-		skipVerify = *env.VaultSkipVerify
+		return "", err
 	}
 
 	// Decrypt kubeconfig once: parse the API server URL up here (need
@@ -184,11 +172,10 @@ func (m *Argocd) CreateVaultKubernetesAuth(
 		return "", fmt.Errorf("kubectl apply: %w", err)
 	}
 
-	// Phase 2: in a kubectl+curl+jq container, wait for kubelet to
-	// populate the SA-token Secret, then drive the Vault HTTP API.
-	vaultOut, err := m.vaultK8sAuthConfigure(
-		ctx,
-		env.VaultAddr, env.VaultToken, skipVerify,
+	// Phase 2: wait for the token controller to populate the SA-token
+	// Secret, then drive the Vault HTTP API.
+	vaultOut, err := vaultK8sAuthConfigure(
+		ctx, vc,
 		apiServer, clusterName, authName, namespace,
 		policies, tokenTtl, kubeconfigSecret,
 	)
@@ -199,108 +186,51 @@ func (m *Argocd) CreateVaultKubernetesAuth(
 }
 
 // vaultK8sAuthConfigure waits for the SA-token Secret to be populated
-// by kubelet, then issues three Vault HTTP calls: ensure the auth mount
-// exists, upsert the backend config (host + reviewer JWT + CA), upsert
-// the role. All in a single alpine/k8s container (ships kubectl + curl
-// + jq).
-func (m *Argocd) vaultK8sAuthConfigure(
+// by the token controller, then issues three Vault calls: ensure the
+// auth mount exists (an existing mount is kept), upsert the backend
+// config (host + reviewer JWT + CA), upsert the role.
+func vaultK8sAuthConfigure(
 	ctx context.Context,
-	vaultAddr, vaultToken string,
-	skipVerify bool,
+	vc *vault.Client,
 	apiServer, clusterName, authName, namespace string,
 	policies []string, tokenTtl string,
 	kubeconfigSecret *dagger.Secret,
 ) (string, error) {
-	addr := strings.TrimRight(vaultAddr, "/")
 	mountPath := fmt.Sprintf("%s-%s", clusterName, authName)
-	curlBase := `curl -fsS`
-	if skipVerify {
-		curlBase += " -k"
-	}
 
-	mountPayload, err := json.Marshal(map[string]string{"type": "kubernetes"})
+	reviewerJWT, caB64, err := readServiceAccountToken(ctx, kubeconfigSecret, namespace, authName)
 	if err != nil {
-		return "", fmt.Errorf("marshal mount payload: %w", err)
+		return "", fmt.Errorf("read SA token: %w", err)
 	}
-	rolePayload, err := json.Marshal(map[string]any{
-		"bound_service_account_names":      []string{authName},
-		"bound_service_account_namespaces": []string{namespace},
-		"token_ttl":                        tokenTtl,
-		"token_policies":                   policies,
-	})
+	caPEM, err := base64.StdEncoding.DecodeString(caB64)
 	if err != nil {
-		return "", fmt.Errorf("marshal role payload: %w", err)
+		return "", fmt.Errorf("decode SA ca.crt: %w", err)
 	}
 
-	// jsonpath uses `\.` to escape the literal `.` in `ca.crt`. Go raw
-	// string + bash single-quote both preserve it byte-for-byte.
-	waitForSecret := fmt.Sprintf(`for i in $(seq 1 30); do
-  TOKEN=$(kubectl -n %[1]s get secret %[2]s -o jsonpath='{.data.token}' 2>/dev/null || true)
-  CA=$(kubectl -n %[1]s get secret %[2]s -o jsonpath='{.data.ca\.crt}' 2>/dev/null || true)
-  if [ -n "$TOKEN" ] && [ -n "$CA" ]; then break; fi
-  sleep 2
-done
-if [ -z "$TOKEN" ] || [ -z "$CA" ]; then
-  echo "SA-token Secret %[1]s/%[2]s never populated after 60s" >&2
-  exit 1
-fi
-TOKEN_JWT=$(printf %%s "$TOKEN" | base64 -d)
-CA_PEM=$(printf %%s "$CA" | base64 -d)`, namespace, authName)
-
-	// Ensure auth mount exists. Vault returns HTTP 400 with body
-	// `path is already in use` when the mount is present — treat that
-	// as success (idempotent re-run).
-	ensureMount := fmt.Sprintf(`HTTP=$(%s -o /tmp/mount.out -w '%%{http_code}' -X POST \
-  -H "X-Vault-Token: ${VAULT_TOKEN}" -H "Content-Type: application/json" \
-  --data %q "%s/v1/sys/auth/%s" || true)
-case "$HTTP" in
-  2*) ;;
-  400) grep -q "path is already in use" /tmp/mount.out || { cat /tmp/mount.out >&2; exit 1; } ;;
-  *)   cat /tmp/mount.out >&2; exit 1 ;;
-esac`, curlBase, string(mountPayload), addr, mountPath)
-
-	// Upsert backend config. Build the JSON via jq so the multi-line
-	// CA PEM survives shell quoting intact.
-	configBackend := fmt.Sprintf(`jq -n --arg host %q --arg ca "$CA_PEM" --arg jwt "$TOKEN_JWT" \
-  '{kubernetes_host:$host,kubernetes_ca_cert:$ca,token_reviewer_jwt:$jwt,disable_iss_validation:true,disable_local_ca_jwt:true}' \
-  > /tmp/config.json
-%s -X POST -H "X-Vault-Token: ${VAULT_TOKEN}" -H "Content-Type: application/json" \
-  --data @/tmp/config.json "%s/v1/auth/%s/config"`, apiServer, curlBase, addr, mountPath)
-
-	// Upsert role.
-	configRole := fmt.Sprintf(`%s -X POST -H "X-Vault-Token: ${VAULT_TOKEN}" -H "Content-Type: application/json" \
-  --data %q "%s/v1/auth/%s/role/%s"
-echo "vault k8s auth %s/%s ready (policies: %s)"`,
-		curlBase, string(rolePayload), addr, mountPath, authName,
-		mountPath, authName, strings.Join(policies, ","))
-
-	script := strings.Join([]string{
-		"set -euo pipefail",
-		"export KUBECONFIG=/work/kubeconfig",
-		waitForSecret,
-		ensureMount,
-		configBackend,
-		configRole,
-	}, "\n")
-
-	vaultTokenSecret := dag.SetSecret("vault-k8s-auth-admin-token", vaultToken)
-	cacheBuster := time.Now().UTC().Format(time.RFC3339Nano)
-
-	// alpine/k8s ships kubectl + curl + jq + yq in one image — saves an
-	// `apk add` round trip and pins the kubectl version to a known
-	// K8s minor. Bump the tag in lockstep with cluster K8s versions.
-	ctr := dag.Container().
-		From("alpine/k8s:1.31.0").
-		WithMountedSecret("/work/kubeconfig", kubeconfigSecret).
-		WithSecretVariable("VAULT_TOKEN", vaultTokenSecret).
-		WithEnvVariable("CACHE_BUSTER", cacheBuster).
-		WithExec([]string{"sh", "-c", script})
-
-	out, err := ctr.Stdout(ctx)
-	if err != nil {
-		return "", err
+	if _, err := vc.EnableAuth(ctx, mountPath, "kubernetes"); err != nil {
+		return "", fmt.Errorf("enable auth/%s: %w", mountPath, err)
+	}
+	// disable_iss_validation + disable_local_ca_jwt match the
+	// vault-base-setup Terraform module.
+	if err := vc.WriteKubernetesAuthConfig(ctx, mountPath, vault.KubernetesAuthConfig{
+		KubernetesHost:       apiServer,
+		KubernetesCACert:     string(caPEM),
+		TokenReviewerJWT:     reviewerJWT,
+		DisableISSValidation: true,
+		DisableLocalCAJWT:    true,
+	}); err != nil {
+		return "", fmt.Errorf("configure auth/%s: %w", mountPath, err)
+	}
+	if err := vc.WriteKubernetesAuthRole(ctx, mountPath, authName, vault.KubernetesAuthRole{
+		BoundServiceAccountNames:      []string{authName},
+		BoundServiceAccountNamespaces: []string{namespace},
+		TokenTTL:                      tokenTtl,
+		TokenPolicies:                 policies,
+	}); err != nil {
+		return "", fmt.Errorf("write role %s: %w", authName, err)
 	}
-	return strings.TrimSpace(out), nil
+	return fmt.Sprintf("vault k8s auth %s/%s ready (policies: %s)",
+		mountPath, authName, strings.Join(policies, ",")), nil
 }
 
 // vaultK8sAuthManifestTemplate renders the 4-document YAML
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"dagger/argocd/internal/dagger"
+	"dagger/argocd/internal/vault"
+)
+
+// DeleteVaultIssuer is the teardown counterpart of CreateVaultIssuer:
+//
+//  1. Reads the token from `<target-namespace>/<token-secret-name>` and
+//     revokes it in Vault (`auth/token/revoke`). Only a 400 "bad token"
+//     counts as already revoked; any other failure (including a 403 for an
+//     admin token without revoke permission) stops before the Secret is
+//     deleted, so a live token is never orphaned.
+//  2. Deletes the token Secret and the CA Secret on the target cluster,
+//     and the Namespace when --delete-namespace=true.
+//  3. With --delete-policy=true also deletes the ACL policy. Off by
+//     default: the policy is usually shared by every cluster's issuer.
+//
+// Missing objects are skipped, so the function is safe to re-run.
+func (m *Argocd) DeleteVaultIssuer(
+	ctx context.Context,
+	// Target cluster name (for the summary).
+	clusterName string,
+	// SOPS-encrypted kubeconfig of the target cluster.
+	kubeconfigSourceFile *dagger.File,
+	// SOPS-encrypted KV-YAML with `vaultAddr`, `vaultToken` and the
+	// optional TLS/namespace keys (see create-vault-issuer).
+	vaultEnvFile *dagger.File,
+	// AGE private key for decrypting both files.
+	sopsKey *dagger.Secret,
+	// Vault ACL policy (only deleted with --delete-policy=true).
+	// +optional
+	// +default="pki-issue"
+	policyName string,
+	// Delete the ACL policy in Vault.
+	// +optional
+	// +default=false
+	deletePolicy bool,
+	// Namespace of the cert-manager Secrets.
+	// +optional
+	// +default="cert-manager"
+	targetNamespace string,
+	// Delete targetNamespace too (off: it usually hosts cert-manager).
+	// +optional
+	// +default=false
+	deleteNamespace bool,
+	// +optional
+	// +default="cert-manager-vault-token"
+	tokenSecretName string,
+	// +optional
+	// +default="vault-pki-ca"
+	caSecretName string,
+
+	// Arbitrary string mixed into the function-level cache key (see
+	// create-vault-issuer).
+	// +optional
+	cacheBuster string,
+) (string, error) {
+	_ = cacheBuster
+	if clusterName == "" {
+		return "", fmt.Errorf("cluster-name is required")
+	}
+	if kubeconfigSourceFile == nil || vaultEnvFile == nil || sopsKey == nil {
+		return "", fmt.Errorf("kubeconfig-source-file, vault-env-file and sops-key are required")
+	}
+
+	_, vc, err := decryptVaultEnv(ctx, sopsKey, vaultEnvFile)
+	if err != nil {
+		return "", err
+	}
+	kubeconfigYaml, err := dag.Secrets().Decrypt(ctx, sopsKey, kubeconfigSourceFile)
+	if err != nil {
+		return "", fmt.Errorf("decrypt kubeconfig: %w", err)
+	}
+	kubeconfigSecret := dag.SetSecret("vault-issuer-delete-kubeconfig", kubeconfigYaml)
+
+	results := []string{"cluster: " + clusterName}
+
+	// Vault side first: once the Secret is gone the token can no longer
+	// be found.
+	token, found, err := readClusterSecretKey(ctx, kubeconfigSecret, targetNamespace, tokenSecretName, "token")
+	if err != nil {
+		return "", fmt.Errorf("read token secret: %w", err)
+	}
+	if found {
+		err := vc.RevokeToken(ctx, token)
+		switch {
+		case err == nil:
+			results = append(results, "revoked Vault token")
+		case vault.IsStatus(err, http.StatusBadRequest) && vault.IsInvalidToken(err):
+			// Only an explicit "bad token"/"invalid token" means there is
+			// nothing left to revoke. A 403 (e.g. the admin token lacks
+			// auth/token/revoke) must keep the Secret, or the live token
+			// would be orphaned.
+			results = append(results, "Vault token already invalid")
+		case vault.IsPermissionDenied(err):
+			return strings.Join(results, "\n"), fmt.Errorf("token revoke: admin token may not revoke tokens, Secret kept: %w", err)
+		default:
+			return strings.Join(results, "\n"), fmt.Errorf("token revoke: %w", err)
+		}
+	} else {
+		results = append(results, fmt.Sprintf("token Secret %s/%s not found; nothing to revoke", targetNamespace, tokenSecretName))
+	}
+
+	if deletePolicy {
+		deleted, err := vc.DeletePolicy(ctx, policyName)
+		if err != nil {
+			return strings.Join(results, "\n"), fmt.Errorf("delete ACL policy %s: %w", policyName, err)
+		}
+		results = append(results, deletedOrSkipped(deleted, "ACL policy "+policyName))
+	}
+
+	objects := []string{
+		fmt.Sprintf("secret/%s -n %s", tokenSecretName, targetNamespace),
+		fmt.Sprintf("secret/%s -n %s", caSecretName, targetNamespace),
+	}
+	if deleteNamespace {
+		objects = append(objects, "namespace/"+targetNamespace)
+	}
+	out, err := deleteClusterObjects(ctx, kubeconfigSecret, objects)
+	if err != nil {
+		return strings.Join(results, "\n"), fmt.Errorf("delete cluster objects: %w", err)
+	}
+	results = append(results, out)
+
+	return strings.Join(results, "\n"), nil
+}
+
+// DeleteVaultKubernetesAuth is the teardown counterpart of
+// CreateVaultKubernetesAuth:
+//
+//  1. Deletes the role `<auth-name>` and unmounts the auth backend
+//     `<cluster-name>-<auth-name>` in Vault (unmounting also drops its
+//     config and any tokens issued through it).
+//  2. Deletes `ServiceAccount/<auth-name>`, the SA-token
+//     `Secret/<auth-name>` and `ClusterRoleBinding/<auth-name>` on the
+//     target cluster, and the Namespace when --delete-namespace=true.
+//
+// Missing mounts and objects are skipped, so the function is safe to
+// re-run. Policies are left alone — they are owned elsewhere.
+func (m *Argocd) DeleteVaultKubernetesAuth(
+	ctx context.Context,
+	// Target cluster name; prefixes the Vault auth backend path.
+	clusterName string,
+	// SOPS-encrypted kubeconfig of the target cluster.
+	kubeconfigSourceFile *dagger.File,
+	// SOPS-encrypted env YAML (same shape as create-vault-issuer).
+	vaultEnvFile *dagger.File,
+	// AGE private key for decrypting both files.
+	sopsKey *dagger.Secret,
+	// Auth backend + role name (mount path `<cluster-name>-<auth-name>`).
+	// +optional
+	// +default="eso"
+	authName string,
+	// Namespace of the SA + SA-token Secret.
+	// +optional
+	// +default="external-secrets"
+	namespace string,
+	// Delete the namespace too (off: it usually hosts the consumer, e.g. ESO).
+	// +optional
+	// +default=false
+	deleteNamespace bool,
+
+	// Arbitrary string mixed into the function-level cache key (see
+	// create-vault-issuer).
+	// +optional
+	cacheBuster string,
+) (string, error) {
+	_ = cacheBuster
+	if clusterName == "" {
+		return "", fmt.Errorf("cluster-name is required")
+	}
+	if kubeconfigSourceFile == nil || vaultEnvFile == nil || sopsKey == nil {
+		return "", fmt.Errorf("kubeconfig-source-file, vault-env-file and sops-key are required")
+	}
+
+	_, vc, err := decryptVaultEnv(ctx, sopsKey, vaultEnvFile)
+	if err != nil {
+		return "", err
+	}
+	kubeconfigYaml, err := dag.Secrets().Decrypt(ctx, sopsKey, kubeconfigSourceFile)
+	if err != nil {
+		return "", fmt.Errorf("decrypt kubeconfig: %w", err)
+	}
+	kubeconfigSecret := dag.SetSecret("vault-k8s-auth-delete-kubeconfig", kubeconfigYaml)
+
+	mountPath := fmt.Sprintf("%s-%s", clusterName, authName)
+	var results []string
+
+	// Vault: the role lives under the mount, so delete it first; the
+	// unmount then removes the backend config.
+	what := fmt.Sprintf("role %s on auth/%s", authName, mountPath)
+	deleted, err := vc.DeleteKubernetesAuthRole(ctx, mountPath, authName)
+	if err != nil {
+		return "", fmt.Errorf("delete %s: %w", what, err)
+	}
+	results = append(results, deletedOrSkipped(deleted, what))
+
+	what = "auth backend auth/" + mountPath
+	if deleted, err = vc.DisableAuth(ctx, mountPath); err != nil {
+		return strings.Join(results, "\n"), fmt.Errorf("delete %s: %w", what, err)
+	}
+	results = append(results, deletedOrSkipped(deleted, what))
+
+	objects := []string{
+		fmt.Sprintf("clusterrolebinding/%s", authName),
+		fmt.Sprintf("secret/%s -n %s", authName, namespace),
+		fmt.Sprintf("serviceaccount/%s -n %s", authName, namespace),
+	}
+	if deleteNamespace {
+		objects = append(objects, "namespace/"+namespace)
+	}
+	out, err := deleteClusterObjects(ctx, kubeconfigSecret, objects)
+	if err != nil {
+		return strings.Join(results, "\n"), fmt.Errorf("delete cluster objects: %w", err)
+	}
+	results = append(results, out)
+
+	return strings.Join(results, "\n"), nil
+}
+
+// deletedOrSkipped is the summary line for a Vault delete.
+func deletedOrSkipped(deleted bool, what string) string {
+	if deleted {
+		return "deleted " + what
+	}
+	return what + " not found; skipped"
+}
+
+// deleteClusterObjects runs `kubectl delete --ignore-not-found` for every
+// "<kind>/<name> [-n <namespace>]" entry, in order.
+func deleteClusterObjects(ctx context.Context, kubeConfig *dagger.Secret, objects []string) (string, error) {
+	var b strings.Builder
+	b.WriteString("set -eu\nexport KUBECONFIG=/work/kubeconfig\n")
+	for _, o := range objects {
+		fmt.Fprintf(&b, "kubectl delete %s --ignore-not-found --wait=true\n", o)
+	}
+
+	out, err := dag.Container().
+		From("alpine/k8s:1.31.0").
+		WithMountedSecret("/work/kubeconfig", kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", b.String()}).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
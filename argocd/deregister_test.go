@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestReleaseOnDeletePatches(t *testing.T) {
+	rendered := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: philly
+  namespace: argocd
+data:
+  foo: bar
+---
+apiVersion: resources.stuttgart-things.com/v1alpha1
+kind: ClusterbookReservation
+metadata:
+  name: philly
+spec:
+  networkKey: 10.31.101
+  forProvider:
+    createDNS: true
+    releaseOnDelete: false
+`
+	patches, err := releaseOnDeletePatches(rendered)
+	if err != nil {
+		t.Fatalf("releaseOnDeletePatches: %v", err)
+	}
+	if len(patches) != 1 {
+		t.Fatalf("expected 1 patch, got %d", len(patches))
+	}
+	if want := `{"spec":{"forProvider":{"releaseOnDelete":false}}}`; patches[0].patch != want {
+		t.Errorf("patch = %s, want %s", patches[0].patch, want)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"dagger/argocd/internal/dagger"
+)
+
+// cniDaemonSets maps DaemonSet name prefixes to the CNI they belong to.
+// Checked in order; the first match wins.
+var cniDaemonSets = []struct{ prefix, cni string }{
+	{"cilium", "cilium"},
+	{"calico-node", "calico"},
+	{"canal", "canal"},
+	{"kube-flannel", "flannel"},
+	{"weave-net", "weave"},
+	{"antrea-agent", "antrea"},
+	{"kube-router", "kube-router"},
+	{"kube-ovn", "kube-ovn"},
+}
+
+// clusterFacts is the discovered state of a cluster, written under
+// `facts:` in the DiscoverClusterFacts values YAML.
+type clusterFacts struct {
+	APIServer           string   `yaml:"apiServer"`
+	KubernetesVersion   string   `yaml:"kubernetesVersion"`
+	Distribution        string   `yaml:"distribution"`
+	Subnets             []string `yaml:"subnets"`
+	IngressClasses      []string `yaml:"ingressClasses"`
+	StorageClasses      []string `yaml:"storageClasses"`
+	DefaultStorageClass string   `yaml:"defaultStorageClass,omitempty"`
+	CNI                 string   `yaml:"cni"`
+}
+
+// DiscoverClusterFacts inspects the target cluster and returns a values
+// YAML that render-clusterbook-cluster-config / bootstrap-clusterbook-cluster
+// accept as --values-file:
+//
+//	name: philly                 # only when --name is set
+//	networkKey: 10.31.101        # dominant /24, as detect-network-key
+//	clusterLabels:
+//	  k8s-version: v1.31.4
+//	  k8s-distribution: k3s
+//	  cni: cilium
+//	facts:                       # informational, ignored by the KCL module
+//	  apiServer: https://10.31.101.10:6443
+//	  kubernetesVersion: v1.31.4+k3s1
+//	  distribution: k3s
+//	  subnets: [10.31.101.0/24, fd00:10:31:101::/64]
+//	  ingressClasses: [nginx]
+//	  storageClasses: [local-path]
+//	  defaultStorageClass: local-path
+//	  cni: cilium
+//
+// Distribution is one of k3s, rke2, kind, talos or kubernetes. The CNI is
+// derived from well-known DaemonSets (k3s/rke2 without one report their
+// embedded flannel/canal). extraLabels are merged into clusterLabels and
+// win over the discovered ones.
+func (m *Argocd) DiscoverClusterFacts(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// Cluster name written as `name:` (omitted when empty)
+	// +optional
+	name string,
+	// JSON object literal merged into clusterLabels, e.g. {"env":"lab"}
+	// +optional
+	extraLabels string,
+) (*dagger.File, error) {
+	if kubeConfig == nil {
+		return nil, fmt.Errorf("kube-config is required")
+	}
+	var extra map[string]string
+	if extraLabels != "" {
+		if err := json.Unmarshal([]byte(extraLabels), &extra); err != nil {
+			return nil, fmt.Errorf("extra-labels: expected a JSON object of strings: %w", err)
+		}
+	}
+
+	// One container, one file: every section is JSON (or a plain value)
+	// behind an "@@<section>" marker. Failing optional lookups (e.g. no
+	// IngressClass API) leave their section empty.
+	script := `set -u
+export KUBECONFIG=/work/kubeconfig
+{
+  echo "@@nodes";          kubectl get nodes -o json
+  echo "@@version";        kubectl version -o json 2>/dev/null || true
+  echo "@@server";         kubectl config view --minify -o jsonpath='{.clusters[0].cluster.server}'; echo
+  echo "@@ingressclasses"; kubectl get ingressclasses -o json 2>/dev/null || true
+  echo "@@storageclasses"; kubectl get storageclasses -o json 2>/dev/null || true
+  echo "@@daemonsets";     kubectl get daemonsets -A -o json 2>/dev/null || true
+} > /work/facts.txt
+`
+	ctr := dag.Container().
+		From("alpine/k8s:1.31.0").
+		WithMountedSecret("/work/kubeconfig", kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", script})
+	raw, err := ctr.File("/work/facts.txt").Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("discover-cluster-facts: %w", err)
+	}
+
+	values, err := buildClusterFactsValues(raw, name, extra)
+	if err != nil {
+		return nil, fmt.Errorf("discover-cluster-facts: %w", err)
+	}
+	return dag.Directory().
+		WithNewFile("values.yaml", values).
+		File("values.yaml"), nil
+}
+
+// buildClusterFactsValues parses the "@@<section>" output of the
+// DiscoverClusterFacts script and renders the values YAML.
+func buildClusterFactsValues(raw, name string, extraLabels map[string]string) (string, error) {
+	sections := splitSections(raw)
+
+	var nodes nodeList
+	if err := json.Unmarshal([]byte(sections["nodes"]), &nodes); err != nil {
+		return "", fmt.Errorf("parse nodes: %w", err)
+	}
+	networkKey, err := networkKeyFromNodes(nodes)
+	if err != nil {
+		return "", err
+	}
+
+	facts := clusterFacts{
+		APIServer:         strings.TrimSpace(sections["server"]),
+		KubernetesVersion: serverVersion(sections["version"], nodes),
+		Subnets:           nodeSubnets(nodes),
+	}
+	facts.Distribution = detectDistribution(facts.KubernetesVersion, nodes)
+	facts.IngressClasses = objectNames(sections["ingressclasses"], "")
+	facts.StorageClasses = objectNames(sections["storageclasses"], "")
+	if defaults := objectNames(sections["storageclasses"], "storageclass.kubernetes.io/is-default-class"); len(defaults) > 0 {
+		facts.DefaultStorageClass = defaults[0]
+	}
+	facts.CNI = detectCNI(objectNames(sections["daemonsets"], ""), facts.Distribution)
+
+	labels := map[string]string{
+		"k8s-version":      labelValue(facts.KubernetesVersion),
+		"k8s-distribution": facts.Distribution,
+		"cni":              facts.CNI,
+	}
+	for k, v := range extraLabels {
+		labels[k] = v
+	}
+
+	doc := map[string]any{
+		"networkKey":    networkKey,
+		"clusterLabels": labels,
+		"facts":         facts,
+	}
+	if name != "" {
+		doc["name"] = name
+	}
+	return encodeYAML(doc)
+}
+
+// splitSections splits "@@<name>" delimited output into its sections.
+func splitSections(raw string) map[string]string {
+	sections := map[string]string{}
+	current := ""
+	var b strings.Builder
+	flush := func() {
+		if current != "" {
+			sections[current] = b.String()
+		}
+		b.Reset()
+	}
+	for _, line := range strings.Split(raw, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			flush()
+			current = strings.TrimSpace(strings.TrimPrefix(line, "@@"))
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	flush()
+	return sections
+}
+
+// serverVersion returns serverVersion.gitVersion from `kubectl version -o
+// json`, falling back to the first node's kubelet version.
+func serverVersion(raw string, nodes nodeList) string {
+	var v struct {
+		ServerVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"serverVersion"`
+	}
+	if start := strings.Index(raw, "{"); start >= 0 {
+		if err := json.Unmarshal([]byte(raw[start:]), &v); err == nil && v.ServerVersion.GitVersion != "" {
+			return v.ServerVersion.GitVersion
+		}
+	}
+	if len(nodes.Items) > 0 {
+		return nodes.Items[0].Status.NodeInfo.KubeletVersion
+	}
+	return ""
+}
+
+// detectDistribution classifies the cluster from the version suffix, the
+// node OS image and the provider ID.
+func detectDistribution(version string, nodes nodeList) string {
+	for _, n := range nodes.Items {
+		if strings.Contains(strings.ToLower(n.Status.NodeInfo.OSImage), "talos") {
+			return "talos"
+		}
+		if strings.HasPrefix(n.Spec.ProviderID, "kind://") {
+			return "kind"
+		}
+	}
+	switch {
+	case strings.Contains(version, "+rke2"):
+		return "rke2"
+	case strings.Contains(version, "+k3s"):
+		return "k3s"
+	}
+	return "kubernetes"
+}
+
+// detectCNI matches DaemonSet names against cniDaemonSets. k3s and rke2
+// run their default CNI embedded or under generic names.
+func detectCNI(daemonSets []string, distribution string) string {
+	for _, c := range cniDaemonSets {
+		for _, ds := range daemonSets {
+			if strings.HasPrefix(ds, c.prefix) {
+				return c.cni
+			}
+		}
+	}
+	switch distribution {
+	case "k3s":
+		return "flannel"
+	case "rke2":
+		return "canal"
+	case "kind":
+		return "kindnet"
+	}
+	return "unknown"
+}
+
+// nodeSubnets returns the sorted, de-duplicated /24 (IPv4) and /64 (IPv6)
+// networks of the nodes' InternalIP addresses.
+func nodeSubnets(nodes nodeList) []string {
+	seen := map[string]bool{}
+	for _, ip := range nodes.internalIPs() {
+		bits, size := 24, 32
+		if ip.To4() == nil {
+			bits, size = 64, 128
+		}
+		n := &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, size)), Mask: net.CIDRMask(bits, size)}
+		seen[n.String()] = true
+	}
+	subnets := make([]string, 0, len(seen))
+	for s := range seen {
+		subnets = append(subnets, s)
+	}
+	sort.Strings(subnets)
+	return subnets
+}
+
+// objectNames returns the sorted metadata.name of every item in a
+// `kubectl get -o json` list. With annotation set, only items whose
+// annotation is "true" are returned.
+func objectNames(raw, annotation string) []string {
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name        string            `json:"name"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	names := []string{}
+	start := strings.Index(raw, "{")
+	if start < 0 || json.Unmarshal([]byte(raw[start:]), &list) != nil {
+		return names
+	}
+	for _, item := range list.Items {
+		if annotation != "" && item.Metadata.Annotations[annotation] != "true" {
+			continue
+		}
+		names = append(names, item.Metadata.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// labelValue turns a version like "v1.31.4+k3s1" into a valid label value
+// ("v1.31.4") by dropping build metadata and invalid characters.
+func labelValue(s string) string {
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	s = invalidLabelChars.ReplaceAllString(s, "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return strings.Trim(s, "-_.")
+}
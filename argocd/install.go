@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/argocd/internal/dagger"
+)
+
+// Install installs Argo CD on a cluster and seeds it, mirroring
+// Flux.Bootstrap:
+//
+//	0: DecryptSecrets — SOPS-decrypt repositoryCredentials (fail fast)
+//	1: DeployArgoCD — install Argo CD via Helmfile
+//	2: ApplySecrets — apply the decrypted repository credential Secrets
+//	3: SetAdminPassword — bcrypt adminPassword into argocd-secret
+//	4: WaitForReady — rollout status of argocd-server and repo-server
+//	5: ApplyBootstrapApps — initial AppProject and root Application
+//
+// Phases whose inputs are not set are skipped. repositoryCredentials is a
+// SOPS-encrypted multi-document YAML of Secrets labelled
+// argocd.argoproj.io/secret-type=repository|repo-creds (see
+// render-repository-credentials).
+//
+// Returns a multi-line "Phase N: ..." summary.
+func (m *Argocd) Install(
+	ctx context.Context,
+	// Kubeconfig of the target cluster
+	kubeConfig *dagger.Secret,
+	// Argo CD namespace
+	// +optional
+	// +default="argocd"
+	namespace string,
+
+	// --- Helmfile ---
+
+	// Deploy Argo CD via Helmfile (false: only seed an existing installation)
+	// +optional
+	// +default=true
+	deployArgoCD bool,
+	// Helmfile reference
+	// +optional
+	// +default="helmfile.yaml"
+	helmfileRef string,
+	// Directory containing the helmfile
+	// +optional
+	src *dagger.Directory,
+	// Comma-separated key=value pairs for --state-values-set
+	// (e.g., "version=9.0.5,namespace=argocd")
+	// +optional
+	stateValues string,
+
+	// --- Secrets ---
+
+	// SOPS-encrypted YAML with the repository credential Secrets
+	// +optional
+	repositoryCredentials *dagger.File,
+	// AGE private key for decrypting repositoryCredentials
+	// +optional
+	sopsKey *dagger.Secret,
+	// Admin password (stored bcrypt-hashed in argocd-secret)
+	// +optional
+	adminPassword *dagger.Secret,
+
+	// --- Bootstrap apps ---
+
+	// Name of the initial AppProject (empty skips it; the root Application then uses "default")
+	// +optional
+	projectName string,
+	// Comma-separated source repositories allowed in the AppProject
+	// +optional
+	// +default="*"
+	projectSourceRepos string,
+	// Repository URL of the root Application (empty skips it)
+	// +optional
+	rootAppRepoURL string,
+	// Path of the root Application within the repository
+	// +optional
+	// +default="argocd/apps"
+	rootAppPath string,
+	// +optional
+	// +default="HEAD"
+	rootAppRevision string,
+	// +optional
+	// +default="root"
+	rootAppName string,
+	// Enable automated sync (prune + selfHeal) on the root Application
+	// +optional
+	// +default=true
+	rootAppAutoSync bool,
+
+	// --- Readiness ---
+
+	// Comma-separated deployments to wait for
+	// +optional
+	// +default="argocd-server,argocd-repo-server"
+	deployments string,
+	// Rollout timeout per deployment
+	// +optional
+	// +default="5m"
+	waitTimeout string,
+
+	// --- Cache control ---
+
+	// Arbitrary string mixed into the function-level cache key (see
+	// bootstrap-clusterbook-cluster).
+	// +optional
+	cacheBuster string,
+) (string, error) {
+	_ = cacheBuster
+	if kubeConfig == nil {
+		return "", fmt.Errorf("kube-config is required")
+	}
+	if repositoryCredentials != nil && sopsKey == nil {
+		return "", fmt.Errorf("repository-credentials requires --sops-key") // pragma: allowlist secret
+	}
+	if namespace == "" {
+		namespace = "argocd"
+	}
+
+	var results []string
+	phase := func(n int, name, msg string) {
+		results = append(results, fmt.Sprintf("Phase %d: %s — %s", n, name, msg))
+	}
+	fail := func(n int, name string, err error) (string, error) {
+		phase(n, name, "failed")
+		return strings.Join(results, "\n"), fmt.Errorf("%s: %w", strings.ToLower(name), err)
+	}
+
+	// Phase 0: decrypt before anything touches the cluster.
+	var credentials string
+	if repositoryCredentials != nil {
+		plaintext, err := dag.Secrets().Decrypt(ctx, sopsKey, repositoryCredentials)
+		if err != nil {
+			return fail(0, "DecryptSecrets", err)
+		}
+		credentials = plaintext
+		phase(0, "DecryptSecrets", "repository credentials decrypted")
+	} else {
+		phase(0, "DecryptSecrets", "skipped (no --repository-credentials)")
+	}
+
+	// Phase 1: Helmfile.
+	if deployArgoCD {
+		if err := dag.Helm().HelmfileOperation(
+			ctx,
+			dagger.HelmHelmfileOperationOpts{
+				Src:             src,
+				HelmfileRef:     helmfileRef,
+				Operation:       "apply",
+				KubeConfig:      kubeConfig,
+				StateValues:     stateValues,
+				VaultAuthMethod: "approle",
+			},
+		); err != nil {
+			return fail(1, "DeployArgoCD", err)
+		}
+		phase(1, "DeployArgoCD", "Argo CD deployed via Helmfile")
+	} else {
+		phase(1, "DeployArgoCD", "skipped (--deploy-argo-cd=false)")
+	}
+
+	// Phase 2: repository credentials.
+	if credentials != "" {
+		secretsFile := dag.Directory().
+			WithNewFile("repository-credentials.yaml", credentials).
+			File("repository-credentials.yaml")
+		if _, err := m.ApplyConfig(ctx, secretsFile, kubeConfig, namespace); err != nil {
+			return fail(2, "ApplySecrets", err)
+		}
+		phase(2, "ApplySecrets", "repository credentials applied")
+	} else {
+		phase(2, "ApplySecrets", "skipped (no --repository-credentials)")
+	}
+
+	// Phase 3: admin password.
+	if adminPassword != nil {
+		if err := setAdminPassword(ctx, kubeConfig, namespace, adminPassword); err != nil {
+			return fail(3, "SetAdminPassword", err)
+		}
+		phase(3, "SetAdminPassword", "admin.password updated in argocd-secret")
+	} else {
+		phase(3, "SetAdminPassword", "skipped (no --admin-password)")
+	}
+
+	// Phase 4: readiness.
+	if err := waitForDeployments(ctx, kubeConfig, namespace, deployments, waitTimeout); err != nil {
+		return fail(4, "WaitForReady", err)
+	}
+	phase(4, "WaitForReady", "ready: "+deployments)
+
+	// Phase 5: AppProject + root Application.
+	if projectName == "" && rootAppRepoURL == "" {
+		phase(5, "ApplyBootstrapApps", "skipped (no --project-name or --root-app-repo-url)")
+		return strings.Join(results, "\n"), nil
+	}
+	apps, err := buildBootstrapApps(namespace, projectName, projectSourceRepos,
+		rootAppName, rootAppRepoURL, rootAppPath, rootAppRevision, rootAppAutoSync)
+	if err != nil {
+		return fail(5, "ApplyBootstrapApps", err)
+	}
+	appsFile := dag.Directory().
+		WithNewFile("bootstrap-apps.yaml", apps).
+		File("bootstrap-apps.yaml")
+	if _, err := m.ApplyConfig(ctx, appsFile, kubeConfig, namespace); err != nil {
+		return fail(5, "ApplyBootstrapApps", err)
+	}
+	var applied []string
+	if projectName != "" {
+		applied = append(applied, "AppProject/"+projectName)
+	}
+	if rootAppRepoURL != "" {
+		applied = append(applied, "Application/"+rootAppName)
+	}
+	phase(5, "ApplyBootstrapApps", "applied "+strings.Join(applied, ", "))
+
+	return strings.Join(results, "\n"), nil
+}
+
+// setAdminPassword stores the bcrypt hash of password as admin.password in
+// argocd-secret, the format Argo CD expects (htpasswd $2y → $2a). The
+// plaintext only exists inside the container as a mounted secret.
+func setAdminPassword(ctx context.Context, kubeConfig *dagger.Secret, namespace string, password *dagger.Secret) error {
+	script := fmt.Sprintf(`set -eu
+apk add --no-cache -q apache2-utils >/dev/null
+HASH=$(htpasswd -nbBC 10 "" "$(cat /work/admin-password)" | tr -d ':\n' | sed 's/$2y/$2a/')
+kubectl patch secret argocd-secret -n %s --type merge \
+  -p "{\"stringData\":{\"admin.password\":\"$HASH\",\"admin.passwordMtime\":\"$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)\"}}" >/dev/null
+`, namespace)
+
+	_, err := dag.Container().
+		From("alpine/k8s:1.31.0").
+		WithMountedSecret("/work/kubeconfig", kubeConfig).
+		WithMountedSecret("/work/admin-password", password).
+		WithEnvVariable("KUBECONFIG", "/work/kubeconfig").
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", script}).
+		Sync(ctx)
+	return err
+}
+
+// waitForDeployments runs `kubectl rollout status` for every deployment in
+// the comma-separated list.
+func waitForDeployments(ctx context.Context, kubeConfig *dagger.Secret, namespace, deployments, timeout string) error {
+	if timeout == "" {
+		timeout = "5m"
+	}
+	var b strings.Builder
+	b.WriteString("set -eu\n")
+	for _, d := range splitList(deployments) {
+		fmt.Fprintf(&b, "kubectl rollout status deployment/%s -n %s --timeout=%s\n", d, namespace, timeout)
+	}
+
+	_, err := dag.Container().
+		From("alpine/k8s:1.31.0").
+		WithMountedSecret("/work/kubeconfig", kubeConfig).
+		WithEnvVariable("KUBECONFIG", "/work/kubeconfig").
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", b.String()}).
+		Sync(ctx)
+	return err
+}
+
+// buildBootstrapApps renders the initial AppProject (when projectName is
+// set) and root Application (when repoURL is set) as one multi-document
+// YAML.
+func buildBootstrapApps(namespace, projectName, sourceRepos, appName, repoURL, path, revision string, autoSync bool) (string, error) {
+	var docs []string
+
+	project := "default"
+	if projectName != "" {
+		project = projectName
+		repos := splitList(sourceRepos)
+		if len(repos) == 0 {
+			repos = []string{"*"}
+		}
+		doc, err := encodeYAML(map[string]any{
+			"apiVersion": "argoproj.io/v1alpha1",
+			"kind":       "AppProject",
+			"metadata": map[string]any{
+				"name":      projectName,
+				"namespace": namespace,
+			},
+			"spec": map[string]any{
+				"sourceRepos": repos,
+				"destinations": []any{
+					map[string]any{"server": "*", "namespace": "*"},
+				},
+				"clusterResourceWhitelist": []any{
+					map[string]any{"group": "*", "kind": "*"},
+				},
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("marshal appproject: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+
+	if repoURL != "" {
+		if appName == "" {
+			appName = "root"
+		}
+		if revision == "" {
+			revision = "HEAD"
+		}
+		spec := map[string]any{
+			"project": project,
+			"source": map[string]any{
+				"repoURL":        repoURL,
+				"targetRevision": revision,
+				"path":           path,
+			},
+			"destination": map[string]any{
+				"server":    "https://kubernetes.default.svc",
+				"namespace": namespace,
+			},
+		}
+		if autoSync {
+			spec["syncPolicy"] = map[string]any{
+				"automated": map[string]any{"prune": true, "selfHeal": true},
+			}
+		}
+		doc, err := encodeYAML(map[string]any{
+			"apiVersion": "argoproj.io/v1alpha1",
+			"kind":       "Application",
+			"metadata": map[string]any{
+				"name":      appName,
+				"namespace": namespace,
+			},
+			"spec": spec,
+		})
+		if err != nil {
+			return "", fmt.Errorf("marshal root application: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+
+	return strings.Join(docs, "---\n"), nil
+}
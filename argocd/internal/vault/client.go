@@ -0,0 +1,274 @@
+// Package vault is a small client for the parts of the Vault HTTP API the
+// argocd module drives: ACL policies, token create/lookup/renew/revoke,
+// the PKI CA and Kubernetes auth backends.
+//
+// Requests carry the admin token in X-Vault-Token and, for Vault
+// Enterprise, the namespace in X-Vault-Namespace. For idempotent calls
+// (see idempotent) 5xx responses (and 429) and transport errors are
+// retried with exponential backoff; everything else, including any
+// failure of a token create, is returned after one attempt.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config configures a Client. Address and Token are required.
+type Config struct {
+	// Address of the Vault server, e.g. https://vault.example.com:8200.
+	Address string
+	// Token sent as X-Vault-Token.
+	Token string
+	// Namespace sent as X-Vault-Namespace (Vault Enterprise). Optional.
+	Namespace string
+	// CACert is the PEM bundle used to verify the server certificate
+	// instead of the system roots.
+	CACert []byte
+	// InsecureSkipVerify disables server certificate verification.
+	InsecureSkipVerify bool
+	// MaxRetries is the number of retries after the first attempt
+	// (default 3; negative disables retries).
+	MaxRetries int
+	// MinBackoff is the wait before the first retry, doubled per retry
+	// up to MaxBackoff (defaults 250ms and 5s).
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// HTTPClient overrides the client built from the TLS settings.
+	HTTPClient *http.Client
+}
+
+// Client talks to one Vault server with one token.
+type Client struct {
+	addr       string
+	token      string
+	namespace  string
+	http       *http.Client
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// New returns a Client for cfg.
+func New(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("vault: address is required")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("vault: token is required")
+	}
+	c := &Client{
+		addr:       strings.TrimRight(cfg.Address, "/"),
+		token:      cfg.Token,
+		namespace:  strings.Trim(cfg.Namespace, "/"),
+		http:       cfg.HTTPClient,
+		maxRetries: cfg.MaxRetries,
+		minBackoff: cfg.MinBackoff,
+		maxBackoff: cfg.MaxBackoff,
+	}
+	switch {
+	case c.maxRetries == 0:
+		c.maxRetries = 3
+	case c.maxRetries < 0:
+		c.maxRetries = 0
+	}
+	if c.minBackoff <= 0 {
+		c.minBackoff = 250 * time.Millisecond
+	}
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = 5 * time.Second
+	}
+	if c.http == nil {
+		tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify} //nolint:gosec // opt-in via vaultSkipVerify
+		if len(cfg.CACert) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(cfg.CACert) {
+				return nil, errors.New("vault: CA certificate contains no PEM certificates")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		c.http = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}
+	}
+	return c, nil
+}
+
+// ResponseError is a non-2xx answer from Vault.
+type ResponseError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	// Errors is the "errors" array of the response, or the raw body when
+	// it is not JSON.
+	Errors []string
+}
+
+func (e *ResponseError) Error() string {
+	msg := strings.Join(e.Errors, "; ")
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("vault: %s /v1/%s: HTTP %d: %s", e.Method, e.Path, e.StatusCode, msg)
+}
+
+// contains reports whether any error message contains s.
+func (e *ResponseError) contains(s string) bool {
+	for _, m := range e.Errors {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStatus reports whether err is a *ResponseError with one of codes.
+func IsStatus(err error, codes ...int) bool {
+	var re *ResponseError
+	if !errors.As(err, &re) {
+		return false
+	}
+	for _, code := range codes {
+		if re.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// do sends in (JSON-encoded when non-nil) to /v1/<path> and returns the
+// raw response body. Retryable failures of idempotent calls are retried
+// with backoff.
+func (c *Client) do(ctx context.Context, method, path string, in any) ([]byte, error) {
+	path = strings.TrimLeft(path, "/")
+	maxRetries := c.maxRetries
+	if !idempotent(method, path) {
+		maxRetries = 0
+	}
+	var payload []byte
+	if in != nil {
+		var err error
+		if payload, err = json.Marshal(in); err != nil {
+			return nil, fmt.Errorf("vault: marshal %s payload: %w", path, err)
+		}
+	}
+
+	wait := c.minBackoff
+	for attempt := 0; ; attempt++ {
+		body, err := c.once(ctx, method, path, payload)
+		if err == nil || attempt >= maxRetries || !retryable(err) {
+			return body, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > c.maxBackoff {
+			wait = c.maxBackoff
+		}
+	}
+}
+
+// once performs a single HTTP round trip.
+func (c *Client) once(ctx context.Context, method, path string, payload []byte) ([]byte, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+"/v1/"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s /v1/%s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s /v1/%s: read body: %w", method, path, err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return data, nil
+	}
+
+	re := &ResponseError{Method: method, Path: path, StatusCode: resp.StatusCode}
+	var parsed struct {
+		Errors []string `json:"errors"`
+	}
+	if json.Unmarshal(data, &parsed) == nil && len(parsed.Errors) > 0 {
+		re.Errors = parsed.Errors
+	} else if s := strings.TrimSpace(string(data)); s != "" {
+		re.Errors = []string{s}
+	}
+	return nil, re
+}
+
+// idempotent reports whether repeating method on path cannot create a
+// duplicate. GET, PUT and DELETE always qualify. Of the POSTs, token
+// lookup/renew/revoke and the config/role upserts of an auth backend do,
+// and so does mounting one (EnableAuth treats "path is already in use" as
+// success). Token create does not: a retry after a lost response would
+// mint a second, unrecorded token.
+func idempotent(method, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		switch {
+		case path == "auth/token/lookup", path == "auth/token/renew", path == "auth/token/revoke":
+			return true
+		case strings.HasPrefix(path, "auth/token/"):
+			return false
+		case strings.HasPrefix(path, "sys/auth/"):
+			return true
+		case strings.HasPrefix(path, "auth/"):
+			_, rest, _ := strings.Cut(strings.TrimPrefix(path, "auth/"), "/")
+			return rest == "config" || strings.HasPrefix(rest, "role/")
+		}
+	}
+	return false
+}
+
+// retryable reports whether err is worth another attempt: 5xx, 429 and
+// transport errors (but not a cancelled context).
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var re *ResponseError
+	if errors.As(err, &re) {
+		return re.StatusCode >= 500 || re.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// decode sends the request and unmarshals the JSON response into out.
+func (c *Client) decode(ctx context.Context, method, path string, in, out any) error {
+	data, err := c.do(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("vault: parse %s response: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault records requests and answers them from handler.
+type fakeVault struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []map[string]any
+}
+
+func newFakeVault(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, n int)) (*fakeVault, *httptest.Server) {
+	t.Helper()
+	f := &fakeVault{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.mu.Lock()
+		f.requests = append(f.requests, r)
+		f.bodies = append(f.bodies, body)
+		n := len(f.requests)
+		f.mu.Unlock()
+		handler(w, r, n)
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func newTestClient(t *testing.T, addr string) *Client {
+	t.Helper()
+	c, err := New(Config{
+		Address:    addr,
+		Token:      "root-token", // pragma: allowlist secret
+		Namespace:  "admin/team-a/",
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 2 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestPutPolicySendsHeadersAndBody(t *testing.T) {
+	f, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, _ int) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := newTestClient(t, srv.URL+"/")
+
+	if err := c.PutPolicy(context.Background(), "pki-issue", `path "pki/issue/*" {}`); err != nil {
+		t.Fatalf("PutPolicy: %v", err)
+	}
+	r := f.requests[0]
+	if r.Method != http.MethodPut || r.URL.Path != "/v1/sys/policies/acl/pki-issue" {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}
+	if got := r.Header.Get("X-Vault-Token"); got != "root-token" { // pragma: allowlist secret
+		t.Errorf("X-Vault-Token = %q", got)
+	}
+	if got := r.Header.Get("X-Vault-Namespace"); got != "admin/team-a" {
+		t.Errorf("X-Vault-Namespace = %q", got)
+	}
+	if got := f.bodies[0]["policy"]; got != `path "pki/issue/*" {}` {
+		t.Errorf("policy = %v", got)
+	}
+}
+
+func TestRetryOn5xx(t *testing.T) {
+	f, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		if n < 3 {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{"errors": []string{"Vault is sealed"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"display_name": "cert-manager-philly"}})
+	})
+	c := newTestClient(t, srv.URL)
+
+	info, err := c.LookupToken(context.Background(), "hvs.child")
+	if err != nil {
+		t.Fatalf("LookupToken: %v", err)
+	}
+	if info.DisplayName != "cert-manager-philly" {
+		t.Errorf("display_name = %q", info.DisplayName)
+	}
+	if len(f.requests) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(f.requests))
+	}
+	if got := f.bodies[2]["token"]; got != "hvs.child" {
+		t.Errorf("token = %v", got)
+	}
+}
+
+func TestCreateTokenNotRetried(t *testing.T) {
+	f, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, _ int) {
+		writeJSON(w, http.StatusBadGateway, map[string]any{"errors": []string{"upstream timeout"}})
+	})
+	c := newTestClient(t, srv.URL)
+
+	_, err := c.CreateToken(context.Background(), TokenRequest{
+		Policies:    []string{"pki-issue"},
+		DisplayName: "cert-manager-philly",
+		TTL:         "8760h",
+		Renewable:   true,
+	})
+	if !IsStatus(err, http.StatusBadGateway) {
+		t.Fatalf("expected HTTP 502, got %v", err)
+	}
+	if len(f.requests) != 1 {
+		t.Errorf("expected a single attempt, got %d", len(f.requests))
+	}
+	if got := f.bodies[0]["display_name"]; got != "cert-manager-philly" {
+		t.Errorf("display_name = %v", got)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	for _, tc := range []struct {
+		method, path string
+		want         bool
+	}{
+		{http.MethodPut, "sys/policies/acl/pki-issue", true},
+		{http.MethodGet, "pki/ca/pem", true},
+		{http.MethodPost, "auth/token/lookup", true},
+		{http.MethodPost, "auth/token/create", false},
+		{http.MethodPost, "auth/token/create-orphan", false},
+		{http.MethodPost, "sys/auth/philly-eso", true},
+		{http.MethodPost, "auth/philly-eso/config", true},
+		{http.MethodPost, "auth/philly-eso/role/eso", true},
+		{http.MethodPost, "pki/issue/web", false},
+	} {
+		if got := idempotent(tc.method, tc.path); got != tc.want {
+			t.Errorf("idempotent(%s %s) = %t, want %t", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	f, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, _ int) {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"errors": []string{"internal error"}})
+	})
+	c := newTestClient(t, srv.URL)
+
+	err := c.PutPolicy(context.Background(), "pki-issue", "")
+	if !IsStatus(err, http.StatusInternalServerError) {
+		t.Fatalf("expected HTTP 500, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "PUT /v1/sys/policies/acl/pki-issue: HTTP 500: internal error") {
+		t.Errorf("unexpected error %q", err)
+	}
+	if len(f.requests) != 4 {
+		t.Errorf("expected 1 attempt + 3 retries, got %d", len(f.requests))
+	}
+}
+
+func TestNoRetryOn4xx(t *testing.T) {
+	f, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, _ int) {
+		writeJSON(w, http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+	})
+	c := newTestClient(t, srv.URL)
+
+	_, err := c.LookupToken(context.Background(), "hvs.child")
+	if !IsStatus(err, http.StatusForbidden) {
+		t.Fatalf("expected HTTP 403, got %v", err)
+	}
+	if len(f.requests) != 1 {
+		t.Errorf("expected a single attempt, got %d", len(f.requests))
+	}
+}
+
+func TestInvalidTokenVsPermissionDenied(t *testing.T) {
+	for _, tc := range []struct {
+		status        int
+		message       string
+		invalid, deny bool
+	}{
+		{http.StatusForbidden, "bad token", true, false},
+		{http.StatusBadRequest, "invalid token", true, false},
+		{http.StatusBadRequest, "token not found", true, false},
+		{http.StatusForbidden, "permission denied", false, true},
+		{http.StatusBadRequest, "missing client token", false, false},
+	} {
+		_, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, _ int) {
+			writeJSON(w, tc.status, map[string]any{"errors": []string{tc.message}})
+		})
+		c := newTestClient(t, srv.URL)
+
+		_, err := c.LookupToken(context.Background(), "hvs.expired")
+		if got := IsInvalidToken(err); got != tc.invalid {
+			t.Errorf("%d %q: IsInvalidToken = %t, want %t", tc.status, tc.message, got, tc.invalid)
+		}
+		if got := IsPermissionDenied(err); got != tc.deny {
+			t.Errorf("%d %q: IsPermissionDenied = %t, want %t", tc.status, tc.message, got, tc.deny)
+		}
+	}
+}
+
+func TestTokenLookupAndRenew(t *testing.T) {
+	_, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, _ int) {
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup":
+			writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{
+				"ttl": 3600, "renewable": true, "expire_time": "2026-10-18T12:00:00Z",
+			}})
+		case "/v1/auth/token/renew":
+			writeJSON(w, http.StatusOK, map[string]any{"auth": map[string]any{"lease_duration": 7200}})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	info, err := c.LookupToken(ctx, "hvs.child")
+	if err != nil {
+		t.Fatalf("LookupToken: %v", err)
+	}
+	if info.TTL != time.Hour || !info.Renewable || info.ExpireTime != "2026-10-18T12:00:00Z" {
+		t.Errorf("unexpected lookup %+v", info)
+	}
+	renewed, err := c.RenewToken(ctx, "hvs.child", "8760h")
+	if err != nil {
+		t.Fatalf("RenewToken: %v", err)
+	}
+	if renewed != 2*time.Hour {
+		t.Errorf("renewed = %s", renewed)
+	}
+}
+
+func TestEnableAuthIdempotent(t *testing.T) {
+	f, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		if n == 1 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]any{"errors": []string{"path is already in use at philly-eso/"}})
+	})
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	for i, want := range []bool{true, false} {
+		created, err := c.EnableAuth(ctx, "philly-eso", "kubernetes")
+		if err != nil {
+			t.Fatalf("EnableAuth #%d: %v", i+1, err)
+		}
+		if created != want {
+			t.Errorf("EnableAuth #%d created = %t, want %t", i+1, created, want)
+		}
+	}
+	if got := f.bodies[0]["type"]; got != "kubernetes" {
+		t.Errorf("type = %v", got)
+	}
+}
+
+func TestDeleteToleratesMissing(t *testing.T) {
+	_, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, _ int) {
+		switch r.URL.Path {
+		case "/v1/sys/auth/philly-eso":
+			writeJSON(w, http.StatusBadRequest, map[string]any{"errors": []string{"no matching mount"}})
+		case "/v1/auth/philly-eso/role/eso":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]any{"errors": []string{}})
+		}
+	})
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	if deleted, err := c.DeleteKubernetesAuthRole(ctx, "philly-eso", "eso"); err != nil || !deleted {
+		t.Errorf("DeleteKubernetesAuthRole = %t, %v", deleted, err)
+	}
+	if deleted, err := c.DisableAuth(ctx, "philly-eso"); err != nil || deleted {
+		t.Errorf("DisableAuth = %t, %v", deleted, err)
+	}
+	if deleted, err := c.DeletePolicy(ctx, "pki-issue"); err != nil || deleted {
+		t.Errorf("DeletePolicy = %t, %v", deleted, err)
+	}
+}
+
+func TestReadPKICA(t *testing.T) {
+	_, srv := newFakeVault(t, func(w http.ResponseWriter, r *http.Request, _ int) {
+		if r.URL.Path != "/v1/pki/ca/pem" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"))
+	})
+	c := newTestClient(t, srv.URL)
+
+	ca, err := c.ReadPKICA(context.Background(), "pki")
+	if err != nil {
+		t.Fatalf("ReadPKICA: %v", err)
+	}
+	if !strings.HasSuffix(ca, "-----END CERTIFICATE-----") {
+		t.Errorf("unexpected CA %q", ca)
+	}
+}
+
+func TestCAPinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	ctx := context.Background()
+
+	pinned, err := New(Config{Address: srv.URL, Token: "root-token", CACert: caPEM}) // pragma: allowlist secret
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := pinned.PutPolicy(ctx, "pki-issue", ""); err != nil {
+		t.Errorf("pinned CA: %v", err)
+	}
+
+	unpinned, err := New(Config{Address: srv.URL, Token: "root-token", MaxRetries: -1}) // pragma: allowlist secret
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := unpinned.PutPolicy(ctx, "pki-issue", ""); err == nil ||
+		!strings.Contains(err.Error(), "certificate") {
+		t.Errorf("expected a certificate error, got %v", err)
+	}
+
+	if _, err := New(Config{Address: srv.URL, Token: "root-token", CACert: []byte("not a pem")}); err == nil { // pragma: allowlist secret
+		t.Error("expected an error for an invalid CA bundle")
+	}
+}
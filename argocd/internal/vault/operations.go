@@ -0,0 +1,210 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PutPolicy creates or replaces the ACL policy name.
+func (c *Client) PutPolicy(ctx context.Context, name, hcl string) error {
+	_, err := c.do(ctx, http.MethodPut, "sys/policies/acl/"+name, map[string]string{"policy": hcl})
+	return err
+}
+
+// DeletePolicy deletes the ACL policy name. It reports false when the
+// policy did not exist.
+func (c *Client) DeletePolicy(ctx context.Context, name string) (bool, error) {
+	return c.delete(ctx, "sys/policies/acl/"+name)
+}
+
+// TokenRequest is the body of POST /v1/auth/token/create.
+type TokenRequest struct {
+	Policies    []string `json:"policies,omitempty"`
+	DisplayName string   `json:"display_name,omitempty"`
+	TTL         string   `json:"ttl,omitempty"`
+	Renewable   bool     `json:"renewable"`
+}
+
+// CreateToken mints a child token of the client token and returns it.
+func (c *Client) CreateToken(ctx context.Context, req TokenRequest) (string, error) {
+	var resp struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := c.decode(ctx, http.MethodPost, "auth/token/create", req, &resp); err != nil {
+		return "", err
+	}
+	if resp.Auth.ClientToken == "" {
+		return "", errors.New("vault: auth/token/create returned no client_token")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+// TokenInfo is the subset of a token lookup used by the module.
+type TokenInfo struct {
+	TTL         time.Duration
+	Renewable   bool
+	ExpireTime  string
+	Policies    []string
+	DisplayName string
+}
+
+// LookupToken looks up token. Vault answers 403 "bad token" (or 400 on
+// older versions) for expired and revoked tokens; see IsInvalidToken.
+func (c *Client) LookupToken(ctx context.Context, token string) (*TokenInfo, error) {
+	var resp struct {
+		Data struct {
+			TTL         int      `json:"ttl"`
+			Renewable   bool     `json:"renewable"`
+			ExpireTime  string   `json:"expire_time"`
+			Policies    []string `json:"policies"`
+			DisplayName string   `json:"display_name"`
+		} `json:"data"`
+	}
+	if err := c.decode(ctx, http.MethodPost, "auth/token/lookup", map[string]string{"token": token}, &resp); err != nil {
+		return nil, err
+	}
+	return &TokenInfo{
+		TTL:         time.Duration(resp.Data.TTL) * time.Second,
+		Renewable:   resp.Data.Renewable,
+		ExpireTime:  resp.Data.ExpireTime,
+		Policies:    resp.Data.Policies,
+		DisplayName: resp.Data.DisplayName,
+	}, nil
+}
+
+// RenewToken renews token by increment (a Vault duration such as "8760h")
+// and returns the new lease duration, which Vault caps at the max TTL.
+func (c *Client) RenewToken(ctx context.Context, token, increment string) (time.Duration, error) {
+	var resp struct {
+		Auth struct {
+			LeaseDuration int `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"token": token}
+	if increment != "" {
+		body["increment"] = increment
+	}
+	if err := c.decode(ctx, http.MethodPost, "auth/token/renew", body, &resp); err != nil {
+		return 0, err
+	}
+	return time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// RevokeToken revokes token and its children.
+func (c *Client) RevokeToken(ctx context.Context, token string) error {
+	_, err := c.do(ctx, http.MethodPost, "auth/token/revoke", map[string]string{"token": token})
+	return err
+}
+
+// invalidTokenMessages are the errors Vault returns for a looked-up,
+// renewed or revoked token that is unknown, expired or revoked.
+var invalidTokenMessages = []string{"bad token", "invalid token", "token not found"}
+
+// IsInvalidToken reports whether err is Vault rejecting a looked-up,
+// renewed or revoked token as unknown, expired or revoked. A 403
+// "permission denied" (the caller's token lacks the capability) is not.
+func IsInvalidToken(err error) bool {
+	var re *ResponseError
+	if !errors.As(err, &re) || (re.StatusCode != http.StatusBadRequest && re.StatusCode != http.StatusForbidden) {
+		return false
+	}
+	for _, msg := range invalidTokenMessages {
+		if re.contains(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPermissionDenied reports whether err is Vault refusing the request
+// because the client token lacks the capability (or is itself invalid).
+func IsPermissionDenied(err error) bool {
+	var re *ResponseError
+	return errors.As(err, &re) && re.StatusCode == http.StatusForbidden && re.contains("permission denied")
+}
+
+// ReadPKICA returns the CA certificate PEM of the PKI secrets engine
+// mounted at mount (GET /v1/<mount>/ca/pem).
+func (c *Client) ReadPKICA(ctx context.Context, mount string) (string, error) {
+	data, err := c.do(ctx, http.MethodGet, strings.Trim(mount, "/")+"/ca/pem", nil)
+	if err != nil {
+		return "", err
+	}
+	pem := strings.TrimSuffix(string(data), "\n")
+	if pem == "" {
+		return "", fmt.Errorf("vault: %s/ca/pem is empty", mount)
+	}
+	return pem, nil
+}
+
+// EnableAuth mounts an auth backend of authType at path. It reports false
+// when the path is already in use.
+func (c *Client) EnableAuth(ctx context.Context, path, authType string) (bool, error) {
+	_, err := c.do(ctx, http.MethodPost, "sys/auth/"+path, map[string]string{"type": authType})
+	var re *ResponseError
+	if errors.As(err, &re) && re.StatusCode == http.StatusBadRequest && re.contains("path is already in use") {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DisableAuth unmounts the auth backend at path, dropping its config,
+// roles and issued tokens. It reports false when nothing was mounted.
+func (c *Client) DisableAuth(ctx context.Context, path string) (bool, error) {
+	return c.delete(ctx, "sys/auth/"+path)
+}
+
+// KubernetesAuthConfig is the body of POST /v1/auth/<mount>/config.
+type KubernetesAuthConfig struct {
+	KubernetesHost       string `json:"kubernetes_host"`
+	KubernetesCACert     string `json:"kubernetes_ca_cert,omitempty"`
+	TokenReviewerJWT     string `json:"token_reviewer_jwt,omitempty"`
+	DisableISSValidation bool   `json:"disable_iss_validation"`
+	DisableLocalCAJWT    bool   `json:"disable_local_ca_jwt"`
+}
+
+// WriteKubernetesAuthConfig upserts the config of the Kubernetes auth
+// backend at mount.
+func (c *Client) WriteKubernetesAuthConfig(ctx context.Context, mount string, cfg KubernetesAuthConfig) error {
+	_, err := c.do(ctx, http.MethodPost, "auth/"+mount+"/config", cfg)
+	return err
+}
+
+// KubernetesAuthRole is the body of POST /v1/auth/<mount>/role/<name>.
+type KubernetesAuthRole struct {
+	BoundServiceAccountNames      []string `json:"bound_service_account_names"`
+	BoundServiceAccountNamespaces []string `json:"bound_service_account_namespaces"`
+	TokenTTL                      string   `json:"token_ttl,omitempty"`
+	TokenPolicies                 []string `json:"token_policies"`
+}
+
+// WriteKubernetesAuthRole upserts the role name of the Kubernetes auth
+// backend at mount.
+func (c *Client) WriteKubernetesAuthRole(ctx context.Context, mount, name string, role KubernetesAuthRole) error {
+	_, err := c.do(ctx, http.MethodPost, "auth/"+mount+"/role/"+name, role)
+	return err
+}
+
+// DeleteKubernetesAuthRole deletes the role name of the auth backend at
+// mount. It reports false when the role or the mount did not exist.
+func (c *Client) DeleteKubernetesAuthRole(ctx context.Context, mount, name string) (bool, error) {
+	return c.delete(ctx, "auth/"+mount+"/role/"+name)
+}
+
+// delete issues DELETE /v1/<path>. 404, and the 400 "no matching mount"
+// some Vault versions return for a missing backend, report false.
+func (c *Client) delete(ctx context.Context, path string) (bool, error) {
+	_, err := c.do(ctx, http.MethodDelete, path, nil)
+	var re *ResponseError
+	if errors.As(err, &re) && (re.StatusCode == http.StatusNotFound ||
+		(re.StatusCode == http.StatusBadRequest && re.contains("no matching mount"))) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -47,37 +47,64 @@ func (m *Argocd) DetectNetworkKey(
 		return "", fmt.Errorf("no JSON in kubectl output: %s", strings.TrimSpace(out))
 	}
 
-	var payload struct {
-		Items []struct {
-			Status struct {
-				Addresses []struct {
-					Type    string `json:"type"`
-					Address string `json:"address"`
-				} `json:"addresses"`
-			} `json:"status"`
-		} `json:"items"`
-	}
+	var payload nodeList
 	if err := json.Unmarshal([]byte(out[jsonStart:]), &payload); err != nil {
 		return "", fmt.Errorf("parse kubectl output: %w", err)
 	}
+	return networkKeyFromNodes(payload)
+}
 
-	counts := map[string]int{}
-	for _, n := range payload.Items {
+// nodeList is the subset of `kubectl get nodes -o json` used by
+// DetectNetworkKey and DiscoverClusterFacts.
+type nodeList struct {
+	Items []struct {
+		Metadata struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Spec struct {
+			ProviderID string `json:"providerID"`
+		} `json:"spec"`
+		Status struct {
+			Addresses []struct {
+				Type    string `json:"type"`
+				Address string `json:"address"`
+			} `json:"addresses"`
+			NodeInfo struct {
+				OSImage        string `json:"osImage"`
+				KubeletVersion string `json:"kubeletVersion"`
+			} `json:"nodeInfo"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// internalIPs returns the parsed InternalIP addresses of all nodes.
+func (l nodeList) internalIPs() []net.IP {
+	var ips []net.IP
+	for _, n := range l.Items {
 		for _, a := range n.Status.Addresses {
 			if a.Type != "InternalIP" {
 				continue
 			}
-			ip := net.ParseIP(a.Address)
-			if ip == nil {
-				continue
+			if ip := net.ParseIP(a.Address); ip != nil {
+				ips = append(ips, ip)
 			}
-			v4 := ip.To4()
-			if v4 == nil {
-				continue
-			}
-			counts[fmt.Sprintf("%d.%d.%d", v4[0], v4[1], v4[2])]++
 		}
 	}
+	return ips
+}
+
+// networkKeyFromNodes returns the most frequent /24 prefix of the nodes'
+// IPv4 InternalIP addresses.
+func networkKeyFromNodes(nodes nodeList) (string, error) {
+	counts := map[string]int{}
+	for _, ip := range nodes.internalIPs() {
+		v4 := ip.To4()
+		if v4 == nil {
+			continue
+		}
+		counts[fmt.Sprintf("%d.%d.%d", v4[0], v4[1], v4[2])]++
+	}
 	if len(counts) == 0 {
 		return "", fmt.Errorf("no IPv4 InternalIP addresses found on cluster nodes")
 	}
@@ -87,7 +114,8 @@ func (m *Argocd) DetectNetworkKey(
 		bestN   int
 	)
 	for k, n := range counts {
-		if n > bestN {
+		// Ties resolve to the lowest prefix so the result is stable.
+		if n > bestN || (n == bestN && k < bestKey) {
 			bestKey, bestN = k, n
 		}
 	}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/argocd/internal/dagger"
+	"dagger/argocd/internal/vault"
+)
+
+// RenewVaultIssuerToken keeps the token CreateVaultIssuer landed in
+// `<target-namespace>/<token-secret-name>` alive. Meant for a scheduled
+// job, one call per cluster:
+//
+//  1. Reads the token from the target cluster Secret.
+//  2. Looks it up in Vault (`auth/token/lookup`, with the admin token).
+//  3. Valid, renewable and TTL below renewThreshold → renews it by
+//     tokenTtl. Valid and above the threshold → leaves it alone.
+//  4. Secret missing, token expired/revoked, or not renewable and below
+//     the threshold → mints a new token (same policy + display name as
+//     CreateVaultIssuer) and replaces the Secret. A still-valid previous
+//     token is revoked once the new Secret is applied.
+//
+// A lookup the admin token is not permitted to make fails the run instead
+// of being mistaken for an expired token.
+//
+// Returns a summary with the action taken and the remaining TTL.
+func (m *Argocd) RenewVaultIssuerToken(
+	ctx context.Context,
+	// Target cluster name; used in the Vault token's display_name.
+	clusterName string,
+	// SOPS-encrypted kubeconfig of the target cluster.
+	kubeconfigSourceFile *dagger.File,
+	// SOPS-encrypted KV-YAML with `vaultAddr`, `vaultToken` and the
+	// optional TLS/namespace keys (see create-vault-issuer).
+	vaultEnvFile *dagger.File,
+	// AGE private key for decrypting both files.
+	sopsKey *dagger.Secret,
+	// Vault ACL policy bound to a re-minted token.
+	// +optional
+	// +default="pki-issue"
+	policyName string,
+	// Namespace of the token Secret.
+	// +optional
+	// +default="cert-manager"
+	targetNamespace string,
+	// Name of the Secret containing the Vault token.
+	// +optional
+	// +default="cert-manager-vault-token"
+	tokenSecretName string,
+	// TTL for renewals and re-minted tokens.
+	// +optional
+	// +default="8760h"
+	tokenTtl string,
+	// Renew (or re-mint) when the remaining TTL drops below this.
+	// +optional
+	// +default="720h"
+	renewThreshold string,
+
+	// Arbitrary string mixed into the function-level cache key (see
+	// create-vault-issuer). Scheduled runs must pass a fresh value.
+	// +optional
+	cacheBuster string,
+) (string, error) {
+	_ = cacheBuster
+	if clusterName == "" {
+		return "", fmt.Errorf("cluster-name is required")
+	}
+	if kubeconfigSourceFile == nil || vaultEnvFile == nil || sopsKey == nil {
+		return "", fmt.Errorf("kubeconfig-source-file, vault-env-file and sops-key are required")
+	}
+	threshold, err := time.ParseDuration(renewThreshold)
+	if err != nil {
+		return "", fmt.Errorf("renew-threshold: %w", err)
+	}
+	ttl, err := time.ParseDuration(tokenTtl)
+	if err != nil {
+		return "", fmt.Errorf("token-ttl: %w", err)
+	}
+
+	_, vc, err := decryptVaultEnv(ctx, sopsKey, vaultEnvFile)
+	if err != nil {
+		return "", err
+	}
+	kubeconfigYaml, err := dag.Secrets().Decrypt(ctx, sopsKey, kubeconfigSourceFile)
+	if err != nil {
+		return "", fmt.Errorf("decrypt kubeconfig: %w", err)
+	}
+	kubeconfigSecret := dag.SetSecret("vault-issuer-renew-kubeconfig", kubeconfigYaml)
+
+	results := []string{fmt.Sprintf("cluster: %s (%s/%s)", clusterName, targetNamespace, tokenSecretName)}
+	remint := ""
+	// revokePrevious is set when the replaced token is still valid and
+	// must be revoked once the new one is in place.
+	revokePrevious := false
+
+	current, found, err := readClusterSecretKey(ctx, kubeconfigSecret, targetNamespace, tokenSecretName, "token")
+	if err != nil {
+		return "", fmt.Errorf("read token secret: %w", err)
+	}
+	if !found {
+		remint = "token Secret missing"
+	}
+
+	if remint == "" {
+		lookup, err := vc.LookupToken(ctx, current)
+		switch {
+		case vault.IsInvalidToken(err):
+			remint = "token expired or revoked"
+		case vault.IsPermissionDenied(err):
+			return "", fmt.Errorf("token lookup: admin token may not look up tokens: %w", err)
+		case err != nil:
+			return "", fmt.Errorf("token lookup: %w", err)
+		default:
+			results = append(results, fmt.Sprintf("lookup: valid, renewable=%t, ttl=%s, expires=%s",
+				lookup.Renewable, lookup.TTL, orNone(lookup.ExpireTime)))
+			switch {
+			case lookup.TTL >= threshold:
+				results = append(results, fmt.Sprintf("action: none (ttl above threshold %s)", threshold))
+				results = append(results, "remaining ttl: "+lookup.TTL.String())
+				return strings.Join(results, "\n"), nil
+			case !lookup.Renewable:
+				remint = "token is not renewable"
+				revokePrevious = true
+			default:
+				renewed, err := vc.RenewToken(ctx, current, tokenTtl)
+				if err != nil {
+					return "", fmt.Errorf("token renew: %w", err)
+				}
+				results = append(results, "action: renewed")
+				if renewed < ttl {
+					// Capped by the token's max TTL — the next run re-mints
+					// once it drops below the threshold and renewal stops helping.
+					results = append(results, fmt.Sprintf("warning: renewal capped at %s (max TTL reached)", renewed))
+				}
+				results = append(results, "remaining ttl: "+renewed.String())
+				return strings.Join(results, "\n"), nil
+			}
+		}
+	}
+
+	// Re-mint with the same policy + display name as CreateVaultIssuer
+	// and replace the Secret.
+	token, _, err := vaultProvision(ctx, vc, policyName, clusterName, tokenTtl, false)
+	if err != nil {
+		return "", fmt.Errorf("re-mint token: %w", err)
+	}
+	manifest := fmt.Sprintf(
+		"apiVersion: v1\nkind: Secret\nmetadata:\n  name: %s\n  namespace: %s\ntype: Opaque\ndata:\n  token: %s\n",
+		tokenSecretName, targetNamespace, base64.StdEncoding.EncodeToString([]byte(token)),
+	)
+	if _, err := m.ApplyConfig(ctx,
+		dag.Directory().WithNewFile("vault-token.yaml", manifest).File("vault-token.yaml"),
+		kubeconfigSecret, targetNamespace,
+	); err != nil {
+		return "", fmt.Errorf("replace token secret: %w", err)
+	}
+	results = append(results, fmt.Sprintf("action: re-minted (%s)", remint))
+	if revokePrevious {
+		if err := vc.RevokeToken(ctx, current); err != nil {
+			return strings.Join(results, "\n"), fmt.Errorf("revoke previous token (Secret already replaced): %w", err)
+		}
+		results = append(results, "revoked previous token")
+	}
+	results = append(results, "remaining ttl: "+ttl.String())
+	return strings.Join(results, "\n"), nil
+}
+
+// readClusterSecretKey returns the decoded value of data.<key> of a Secret
+// on the target cluster, and whether the Secret exists.
+func readClusterSecretKey(ctx context.Context, kubeConfig *dagger.Secret, namespace, name, key string) (string, bool, error) {
+	script := fmt.Sprintf(`set -eu
+export KUBECONFIG=/work/kubeconfig
+mkdir -p /out
+if kubectl -n %[1]s get secret %[2]s >/dev/null 2>&1; then
+  echo found > /out/state
+  kubectl -n %[1]s get secret %[2]s -o jsonpath='{.data.%[3]s}' > /out/value
+else
+  echo missing > /out/state
+  : > /out/value
+fi
+`, namespace, name, strings.ReplaceAll(key, ".", `\.`))
+
+	ctr := dag.Container().
+		From("alpine/k8s:1.31.0").
+		WithMountedSecret("/work/kubeconfig", kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", script})
+
+	state, err := ctr.File("/out/state").Contents(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if strings.TrimSpace(state) != "found" {
+		return "", false, nil
+	}
+	encoded, err := ctr.File("/out/value").Contents(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", false, fmt.Errorf("decode data.%s: %w", key, err)
+	}
+	if len(value) == 0 {
+		return "", false, nil
+	}
+	return string(value), true, nil
+}
+
+// orNone returns s, or "none" when s is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
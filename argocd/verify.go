@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"dagger/argocd/internal/dagger"
+)
+
+// argocdConnectionState is the connection state Argo CD reports per
+// cluster. Newer releases report it under info.connectionState, older
+// ones at the top level.
+type argocdConnectionState struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// argocdCluster is the subset of an /api/v1/clusters item used here.
+type argocdCluster struct {
+	Name            string                `json:"name"`
+	Server          string                `json:"server"`
+	ConnectionState argocdConnectionState `json:"connectionState"`
+	Info            struct {
+		ConnectionState argocdConnectionState `json:"connectionState"`
+		ServerVersion   string                `json:"serverVersion"`
+	} `json:"info"`
+}
+
+// state returns info.connectionState, falling back to the top-level field.
+func (c argocdCluster) state() argocdConnectionState {
+	if c.Info.ConnectionState.Status != "" {
+		return c.Info.ConnectionState
+	}
+	return c.ConnectionState
+}
+
+// argocdAPI is a minimal client for the argocd-server REST API.
+type argocdAPI struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// newArgocdAPI returns a client for baseURL. Without a token it logs in
+// with username/password via /api/v1/session.
+func newArgocdAPI(ctx context.Context, baseURL, token, username, password string, insecure bool) (*argocdAPI, error) {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "https://" + baseURL
+	}
+	api := &argocdAPI{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}, //nolint:gosec // opt-in via --insecure
+			},
+		},
+	}
+	if api.token != "" {
+		return api, nil
+	}
+	if password == "" {
+		return nil, fmt.Errorf("either an auth token or a password is required")
+	}
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	if err := api.do(ctx, http.MethodPost, "/api/v1/session",
+		map[string]string{"username": username, "password": password}, &session); err != nil {
+		return nil, fmt.Errorf("login: %w", err)
+	}
+	if session.Token == "" {
+		return nil, fmt.Errorf("login: empty session token")
+	}
+	api.token = session.Token
+	return api, nil
+}
+
+// do sends a JSON request and decodes a JSON response into out (when
+// non-nil). Non-2xx responses are returned as errors including the body.
+func (a *argocdAPI) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(string(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: HTTP %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// findCluster returns the cluster whose name or server equals nameOrServer.
+func (a *argocdAPI) findCluster(ctx context.Context, nameOrServer string) (*argocdCluster, error) {
+	var list struct {
+		Items []argocdCluster `json:"items"`
+	}
+	if err := a.do(ctx, http.MethodGet, "/api/v1/clusters", nil, &list); err != nil {
+		return nil, err
+	}
+	for i := range list.Items {
+		if list.Items[i].Name == nameOrServer || list.Items[i].Server == nameOrServer {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// invalidateClusterCache asks Argo CD to reconnect to the cluster so the
+// connection state is refreshed even when no Application targets it yet.
+func (a *argocdAPI) invalidateClusterCache(ctx context.Context, server string) error {
+	return a.do(ctx, http.MethodPost,
+		"/api/v1/clusters/"+url.PathEscape(server)+"/invalidate-cache", nil, nil)
+}
+
+// VerifyClusterConnection polls argocd-server until the registered
+// cluster reports connectionState Successful — the signal that Argo CD
+// can actually reach it after bootstrap-clusterbook-cluster --deploy=true.
+//
+// The cluster is matched by Argo CD-side name or server URL. Until it
+// appears, or while its state is Unknown/Failed, polling continues; on
+// timeout the error names the last state and a failure class:
+//
+//	tls          certificate / CA problems (x509)
+//	auth         credentials rejected (Unauthorized, Forbidden)
+//	unreachable  DNS, routing, refused or timed-out connections
+//	not-found    the cluster never appeared in Argo CD
+//	unknown      anything else
+//
+// Authenticate with --auth-token (an Argo CD API token) or
+// --username/--password.
+func (m *Argocd) VerifyClusterConnection(
+	ctx context.Context,
+	// argocd-server URL, e.g. https://argocd.example.com
+	argocdServer string,
+	// Argo CD-side cluster name or server URL
+	clusterName string,
+	// Argo CD API token
+	// +optional
+	authToken *dagger.Secret,
+	// +optional
+	// +default="admin"
+	username string,
+	// Password for username (used when no auth token is set)
+	// +optional
+	password *dagger.Secret,
+	// Skip TLS verification of argocd-server
+	// +optional
+	// +default=false
+	insecure bool,
+	// Ask Argo CD to reconnect before polling (invalidate-cache)
+	// +optional
+	// +default=true
+	refresh bool,
+	// +optional
+	// +default="5m"
+	timeout string,
+	// +optional
+	// +default="10s"
+	interval string,
+	// Arbitrary string mixed into the function-level cache key (see
+	// bootstrap-clusterbook-cluster).
+	// +optional
+	cacheBuster string,
+) (string, error) {
+	_ = cacheBuster
+	if argocdServer == "" || clusterName == "" {
+		return "", fmt.Errorf("argocd-server and cluster-name are required")
+	}
+	wait, err := time.ParseDuration(timeout)
+	if err != nil {
+		return "", fmt.Errorf("timeout: %w", err)
+	}
+	every, err := time.ParseDuration(interval)
+	if err != nil {
+		return "", fmt.Errorf("interval: %w", err)
+	}
+
+	var token, pass string
+	if authToken != nil {
+		if token, err = authToken.Plaintext(ctx); err != nil {
+			return "", fmt.Errorf("read auth-token: %w", err)
+		}
+	} else if password != nil {
+		if pass, err = password.Plaintext(ctx); err != nil {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+	}
+
+	api, err := newArgocdAPI(ctx, argocdServer, token, username, pass, insecure)
+	if err != nil {
+		return "", fmt.Errorf("verify-cluster-connection: %w", err)
+	}
+	return waitForClusterConnection(ctx, api, clusterName, refresh, time.Now().Add(wait), every)
+}
+
+// waitForClusterConnection polls until clusterName is Successful or the
+// deadline passes.
+func waitForClusterConnection(ctx context.Context, api *argocdAPI, clusterName string, refresh bool, deadline time.Time, interval time.Duration) (string, error) {
+	var (
+		last      *argocdCluster
+		lastErr   error
+		refreshed bool
+		attempts  int
+	)
+	for {
+		attempts++
+		cluster, err := api.findCluster(ctx, clusterName)
+		lastErr = err
+		if err == nil && cluster != nil {
+			last = cluster
+			if refresh && !refreshed {
+				// Best effort: older servers may not expose the endpoint.
+				_ = api.invalidateClusterCache(ctx, cluster.Server)
+				refreshed = true
+			}
+			if cluster.state().Status == "Successful" {
+				version := cluster.Info.ServerVersion
+				if version == "" {
+					version = "unknown"
+				}
+				return fmt.Sprintf("cluster %s (%s): connection Successful after %d attempt(s), server version %s",
+					cluster.Name, cluster.Server, attempts, version), nil
+			}
+		}
+
+		if !time.Now().Add(interval).Before(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	switch {
+	case last == nil && lastErr != nil:
+		return "", fmt.Errorf("cluster %s: argocd-server not usable: %w", clusterName, lastErr)
+	case last == nil:
+		return "", fmt.Errorf("cluster %s: not-found: not registered in Argo CD", clusterName)
+	}
+	state := last.state()
+	status := state.Status
+	if status == "" {
+		status = "Unknown"
+	}
+	return "", fmt.Errorf("cluster %s (%s): %s: connection %s after %d attempt(s): %s",
+		last.Name, last.Server, classifyConnectionFailure(state.Message), status, attempts, orNone(state.Message))
+}
+
+// classifyConnectionFailure maps an Argo CD connectionState message to a
+// failure class (see VerifyClusterConnection).
+func classifyConnectionFailure(message string) string {
+	msg := strings.ToLower(message)
+	contains := func(subs ...string) bool {
+		for _, s := range subs {
+			if strings.Contains(msg, s) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case contains("x509", "certificate", "tls:"):
+		return "tls"
+	case contains("unauthorized", "forbidden", "401", "403", "authentication", "credentials"):
+		return "auth"
+	case contains("dial tcp", "no such host", "connection refused", "i/o timeout", "no route to host",
+		"network is unreachable", "deadline exceeded", "eof"):
+		return "unreachable"
+	}
+	return "unknown"
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeArgocdServer serves /api/v1/session and /api/v1/clusters. The
+// connection state of the "philly" cluster is taken from states, one entry
+// per list call; the last entry repeats.
+func fakeArgocdServer(t *testing.T, states []argocdConnectionState) (*httptest.Server, *int32) {
+	t.Helper()
+	var (
+		calls       int32
+		invalidated int32
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/session", func(w http.ResponseWriter, r *http.Request) {
+		var creds map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&creds)
+		if creds["username"] != "admin" || creds["password"] != "s3cret" {
+			http.Error(w, `{"error":"invalid username or password"}`, http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"token":"session-token"}`))
+	})
+	mux.HandleFunc("/api/v1/clusters", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer session-token" {
+			http.Error(w, `{"error":"no session information"}`, http.StatusUnauthorized)
+			return
+		}
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(states) {
+			n = len(states) - 1
+		}
+		cluster := argocdCluster{Name: "philly", Server: "https://10.31.101.10:6443"}
+		cluster.Info.ConnectionState = states[n]
+		cluster.Info.ServerVersion = "1.31"
+		_ = json.NewEncoder(w).Encode(map[string]any{"items": []argocdCluster{cluster}})
+	})
+	mux.HandleFunc("/api/v1/clusters/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/invalidate-cache") {
+			atomic.AddInt32(&invalidated, 1)
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		http.NotFound(w, r)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &invalidated
+}
+
+func TestWaitForClusterConnectionSuccessful(t *testing.T) {
+	srv, invalidated := fakeArgocdServer(t, []argocdConnectionState{
+		{Status: "Unknown"},
+		{Status: "Failed", Message: "dial tcp 10.31.101.10:6443: connect: connection refused"},
+		{Status: "Successful"},
+	})
+	ctx := context.Background()
+
+	api, err := newArgocdAPI(ctx, srv.URL, "", "admin", "s3cret", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err := waitForClusterConnection(ctx, api, "philly", true, time.Now().Add(5*time.Second), 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out, "Successful after 3 attempt(s)") {
+		t.Errorf("unexpected summary %q", out)
+	}
+	if atomic.LoadInt32(invalidated) != 1 {
+		t.Errorf("expected one invalidate-cache call, got %d", atomic.LoadInt32(invalidated))
+	}
+}
+
+func TestWaitForClusterConnectionFailureReason(t *testing.T) {
+	srv, _ := fakeArgocdServer(t, []argocdConnectionState{
+		{Status: "Failed", Message: `Get "https://10.31.101.10:6443/version": x509: certificate signed by unknown authority`},
+	})
+	ctx := context.Background()
+
+	api, err := newArgocdAPI(ctx, srv.URL, "", "admin", "s3cret", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = waitForClusterConnection(ctx, api, "https://10.31.101.10:6443", false, time.Now().Add(50*time.Millisecond), 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "philly (https://10.31.101.10:6443): tls: connection Failed") {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	if _, err := waitForClusterConnection(ctx, api, "boston", false, time.Now(), time.Millisecond); err == nil ||
+		!strings.Contains(err.Error(), "not-found") {
+		t.Errorf("expected not-found, got %v", err)
+	}
+}
+
+func TestNewArgocdAPILoginRejected(t *testing.T) {
+	srv, _ := fakeArgocdServer(t, []argocdConnectionState{{Status: "Successful"}})
+	if _, err := newArgocdAPI(context.Background(), srv.URL, "", "admin", "wrong", false); err == nil ||
+		!strings.Contains(err.Error(), "HTTP 401") {
+		t.Errorf("expected login to fail with HTTP 401, got %v", err)
+	}
+}
+
+func TestClassifyConnectionFailure(t *testing.T) {
+	for msg, want := range map[string]string{
+		"x509: certificate has expired or is not yet valid":                         "tls",
+		"the server has asked for the client to provide credentials (Unauthorized)": "auth",
+		"dial tcp: lookup philly.example.com: no such host":                         "unreachable",
+		"something else entirely":                                                   "unknown",
+	} {
+		if got := classifyConnectionFailure(msg); got != want {
+			t.Errorf("classifyConnectionFailure(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"dagger/flux/internal/dagger"
 )
@@ -77,7 +78,8 @@ metadata:
 }
 
 // WaitForReconciliation runs flux check with retry, reconciles sources,
-// and gets all Flux resources.
+// and then waits (see WaitForReady) until every Flux object in the
+// namespace is Ready. Both steps share reconciliationTimeout.
 func (m *Flux) WaitForReconciliation(
 	ctx context.Context,
 	// Target namespace
@@ -96,6 +98,7 @@ func (m *Flux) WaitForReconciliation(
 	fluxCliImage string,
 ) (string, error) {
 	timeoutSecs := parseTimeout(reconciliationTimeout)
+	deadline := time.Now().Add(time.Duration(timeoutSecs) * time.Second)
 
 	retryScript := fmt.Sprintf(`#!/bin/sh
 echo "Waiting for Flux controllers to be deployed by the operator..."
@@ -136,14 +139,11 @@ flux check
 		results = append(results, fmt.Sprintf("Source reconciled:\n%s", reconcileOutput))
 	}
 
-	getAllOutput, err := cli.
-		WithExec([]string{"flux", "get", "all", "-n", namespace}).
-		Stdout(ctx)
+	readyOutput, err := waitForFluxReady(ctx, kubeConfig, namespace, "", "", deadline, "10s", false)
 	if err != nil {
-		results = append(results, fmt.Sprintf("Warning - flux get all failed: %v", err))
-	} else {
-		results = append(results, fmt.Sprintf("Flux resources:\n%s", getAllOutput))
+		return "", fmt.Errorf("wait-for-reconciliation: %w", err)
 	}
+	results = append(results, readyOutput)
 
 	return strings.Join(results, "\n"), nil
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/flux/internal/dagger"
+)
+
+// pushedArtifact is the `flux push artifact --output json` document.
+type pushedArtifact struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+	URL        string `json:"url"`
+}
+
+// PushConfigArtifact packages rendered config (and optional, ideally
+// SOPS-encrypted, secrets) as an OCI artifact and pushes it with the Flux
+// CLI. It is the registry counterpart of CommitConfig for sites that
+// cannot reach GitHub: config.yaml and secrets.yaml are placed at the
+// artifact root, so the FluxInstance sync path is "./".
+//
+// Returns the pushed reference as oci://<repository>:<tag>@<digest>.
+//
+// For a local test registry, bind it as a service; it is reachable as
+// "registry" inside the push container:
+//
+//	docker run -d -p 5000:5000 registry:2
+//	dagger call -m flux push-config-artifact \
+//	  --config-content "$(cat config.yaml)" \
+//	  --url oci://registry:5000/flux/staging --tag 1.0.0 \
+//	  --registry-service tcp://localhost:5000 --insecure
+func (m *Flux) PushConfigArtifact(
+	ctx context.Context,
+	// Config YAML content
+	configContent string,
+	// Artifact repository URL (e.g. oci://ghcr.io/my-org/flux/staging)
+	url string,
+	// Artifact tag (semver recommended)
+	// +optional
+	// +default="latest"
+	tag string,
+	// Secrets YAML content to include (encrypt with SOPS first)
+	// +optional
+	secretsContent string,
+	// Source annotation recorded on the artifact (defaults to url)
+	// +optional
+	source string,
+	// Revision annotation recorded on the artifact (defaults to tag)
+	// +optional
+	revision string,
+	// Registry username
+	// +optional
+	registryUsername *dagger.Secret,
+	// Registry password or token
+	// +optional
+	registryPassword *dagger.Secret,
+	// Registry service to bind as host "registry" (e.g. a local registry:2)
+	// +optional
+	registryService *dagger.Service,
+	// Allow plain HTTP registry connections
+	// +optional
+	// +default=false
+	insecure bool,
+	// Flux CLI container image
+	// +optional
+	// +default="ghcr.io/fluxcd/flux-cli:v2.8.5"
+	fluxCliImage string,
+) (string, error) {
+	if !strings.HasPrefix(url, "oci://") {
+		return "", fmt.Errorf("push-config-artifact: url must start with oci://, got %q", url)
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	if source == "" {
+		source = url
+	}
+	if revision == "" {
+		revision = tag
+	}
+	if (registryUsername == nil) != (registryPassword == nil) {
+		return "", fmt.Errorf("push-config-artifact: registryUsername and registryPassword must be set together")
+	}
+
+	artifactDir := dag.Directory().
+		WithNewFile("config.yaml", configContent)
+	if secretsContent != "" {
+		artifactDir = artifactDir.WithNewFile("secrets.yaml", secretsContent)
+	}
+
+	ctr := dag.Container().
+		From(fluxCliImage).
+		WithDirectory("/artifact", artifactDir).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano))
+	if registryService != nil {
+		ctr = ctr.WithServiceBinding("registry", registryService)
+	}
+	if registryUsername != nil {
+		ctr = ctr.
+			WithSecretVariable("REGISTRY_USERNAME", registryUsername).
+			WithSecretVariable("REGISTRY_PASSWORD", registryPassword)
+	}
+
+	args := []string{
+		url + ":" + tag,
+		"--path=/artifact",
+		"--source=" + source,
+		"--revision=" + revision,
+		"--output=json",
+	}
+	if insecure {
+		args = append(args, "--insecure-registry")
+	}
+
+	// Credentials are expanded inside the container so they never show up
+	// in the exec arguments.
+	out, err := ctr.
+		WithExec(append([]string{"sh", "-c",
+			`exec flux push artifact "$@" ${REGISTRY_USERNAME:+--creds="$REGISTRY_USERNAME:$REGISTRY_PASSWORD"}`,
+			"flux-push"}, args...)).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("push-config-artifact: %w", err)
+	}
+
+	var pushed pushedArtifact
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &pushed); err != nil {
+		return "", fmt.Errorf("push-config-artifact: parse push output: %w", err)
+	}
+	if pushed.Digest == "" {
+		return "", fmt.Errorf("push-config-artifact: no digest in push output: %s", strings.TrimSpace(out))
+	}
+
+	return fmt.Sprintf("%s:%s@%s", url, tag, pushed.Digest), nil
+}
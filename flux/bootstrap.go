@@ -4,124 +4,11 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"dagger/flux/internal/dagger"
 )
 
-// Destroy tears down Flux from a cluster.
-//
-// Phase order:
-//
-//	0: Delete FluxInstance CR
-//	1: Delete Flux secrets
-//	2: Uninstall Flux operator (Helmfile destroy)
-//	3: Delete flux-system namespace
-//
-// Usage:
-//
-//	dagger call -m flux destroy --kube-config file:///tmp/kubeconfig
-func (m *Flux) Destroy(
-	ctx context.Context,
-	// Kubeconfig secret for cluster access
-	kubeConfig *dagger.Secret,
-	// Target namespace
-	// +optional
-	// +default="flux-system"
-	namespace string,
-	// Helmfile reference for Flux operator
-	// +optional
-	// +default="helmfile.yaml"
-	helmfileRef string,
-	// Directory containing the helmfile
-	// +optional
-	src *dagger.Directory,
-	// Flux operator version for Helmfile state values
-	// +optional
-	// +default="0.42.1"
-	operatorVersion string,
-) (string, error) {
-
-	var results []string
-	kubectlImage := "bitnami/kubectl:latest"
-
-	// =========================================================================
-	// Phase 0: Delete FluxInstance CR
-	// =========================================================================
-
-	_, err := dag.Container().
-		From(kubectlImage).
-		WithMountedSecret("/tmp/kubeconfig", kubeConfig, dagger.ContainerWithMountedSecretOpts{
-			Mode: 0444,
-		}).
-		WithEnvVariable("KUBECONFIG", "/tmp/kubeconfig").
-		WithExec([]string{"kubectl", "delete", "fluxinstance", "--all", "-n", namespace, "--ignore-not-found=true"}).
-		Stdout(ctx)
-	if err != nil {
-		results = append(results, fmt.Sprintf("Phase 0: Warning — delete FluxInstance: %v", err))
-	} else {
-		results = append(results, "Phase 0: FluxInstance CRs deleted")
-	}
-
-	// =========================================================================
-	// Phase 1: Delete Flux secrets
-	// =========================================================================
-
-	_, err = dag.Container().
-		From(kubectlImage).
-		WithMountedSecret("/tmp/kubeconfig", kubeConfig, dagger.ContainerWithMountedSecretOpts{
-			Mode: 0444,
-		}).
-		WithEnvVariable("KUBECONFIG", "/tmp/kubeconfig").
-		WithExec([]string{"kubectl", "delete", "secret", "--all", "-n", namespace, "--ignore-not-found=true"}).
-		Stdout(ctx)
-	if err != nil {
-		results = append(results, fmt.Sprintf("Phase 1: Warning — delete secrets: %v", err))
-	} else {
-		results = append(results, "Phase 1: Flux secrets deleted")
-	}
-
-	// =========================================================================
-	// Phase 2: Uninstall Flux operator (Helmfile destroy)
-	// =========================================================================
-
-	err = dag.Helm().HelmfileOperation(
-		ctx,
-		dagger.HelmHelmfileOperationOpts{
-			Src:             src,
-			HelmfileRef:     helmfileRef,
-			Operation:       "destroy",
-			KubeConfig:      kubeConfig,
-			StateValues:     "version=" + operatorVersion,
-			VaultAuthMethod: "approle",
-		},
-	)
-	if err != nil {
-		results = append(results, fmt.Sprintf("Phase 2: Warning — helmfile destroy: %v", err))
-	} else {
-		results = append(results, "Phase 2: Flux operator uninstalled via Helmfile destroy")
-	}
-
-	// =========================================================================
-	// Phase 3: Delete flux-system namespace
-	// =========================================================================
-
-	_, err = dag.Container().
-		From(kubectlImage).
-		WithMountedSecret("/tmp/kubeconfig", kubeConfig, dagger.ContainerWithMountedSecretOpts{
-			Mode: 0444,
-		}).
-		WithEnvVariable("KUBECONFIG", "/tmp/kubeconfig").
-		WithExec([]string{"kubectl", "delete", "namespace", namespace, "--ignore-not-found=true", "--timeout=120s"}).
-		Stdout(ctx)
-	if err != nil {
-		results = append(results, fmt.Sprintf("Phase 3: Warning — delete namespace: %v", err))
-	} else {
-		results = append(results, fmt.Sprintf("Phase 3: Namespace %s deleted", namespace))
-	}
-
-	return strings.Join(results, "\n"), nil
-}
-
 // Bootstrap orchestrates a full Flux bootstrap lifecycle.
 //
 // Phase order:
@@ -129,12 +16,21 @@ func (m *Flux) Destroy(
 //	0: ValidateAgeKeyPair (secrets module) — fail fast on key mismatch
 //	1: RenderConfig — render all manifests
 //	2: EncryptString (secrets module) — encrypt before committing
-//	3: CommitConfig — push to Git
+//	3: CommitConfig — push to Git (PushConfigArtifact with syncSource=oci)
 //	4: DeployOperator — install operator (Helmfile)
 //	5: ApplyConfig — apply FluxInstance CR
 //	6: ApplySecrets — apply AFTER operator is running
 //	7: VerifySecrets — confirm secrets exist
 //	8: WaitForReconciliation — wait for Flux to reconcile
+//
+// With --plan=true only phases 0 and 1 run (both are read-only); the
+// remaining phases are evaluated and returned as a JSON plan listing what
+// would be committed, which objects would be applied and which phases
+// would be skipped and why.
+//
+// With --sync-source=oci the FluxInstance syncs from an OCIRepository at
+// ociUrl:ociTag instead of git, and Phase 3 pushes config.yaml and
+// secrets.yaml as that artifact (see PushConfigArtifact).
 func (m *Flux) Bootstrap(
 	ctx context.Context,
 	// OCI KCL module source for rendering Flux instance config
@@ -184,6 +80,23 @@ func (m *Flux) Bootstrap(
 	// +optional
 	// +default="main"
 	branchName string,
+	// Base branch for the pull request; when it differs from branchName the
+	// commit goes to branchName (created from baseBranch if missing)
+	// +optional
+	// +default="main"
+	baseBranch string,
+	// Open a pull request from branchName into baseBranch after committing
+	// +optional
+	// +default=false
+	createPR bool,
+	// Merge the pull request after creation
+	// +optional
+	// +default=false
+	mergePR bool,
+	// Merge method: "squash", "merge", or "rebase"
+	// +optional
+	// +default="squash"
+	mergeMethod string,
 	// Destination path within the repository
 	// +optional
 	// +default="clusters/"
@@ -238,64 +151,127 @@ func (m *Flux) Bootstrap(
 	// +optional
 	// +default="0.47.0"
 	operatorVersion string,
+	// FluxInstance sync source: "git" or "oci"
+	// +optional
+	// +default="git"
+	syncSource string,
+	// OCI artifact repository for syncSource=oci (e.g. oci://registry.local/flux/staging)
+	// +optional
+	ociUrl string,
+	// OCI artifact tag for syncSource=oci
+	// +optional
+	// +default="latest"
+	ociTag string,
+	// Pull secret the cluster uses for the OCI registry (must exist in namespace)
+	// +optional
+	ociPullSecret string,
+	// Push the config artifact in Phase 3 (syncSource=oci)
+	// +optional
+	// +default=true
+	pushArtifact bool,
+	// Registry username for pushing the artifact
+	// +optional
+	registryUsername *dagger.Secret,
+	// Registry password or token for pushing the artifact
+	// +optional
+	registryPassword *dagger.Secret,
+	// Only render and evaluate flags; return a JSON plan of the phases
+	// without touching git or the cluster
+	// +optional
+	// +default=false
+	plan bool,
+	// Output format: "text" (Phase N lines) or "json" (per-phase status,
+	// duration, error and artifacts). A failing phase returns an error in
+	// both modes.
+	// +optional
+	// +default="text"
+	outputFormat string,
 ) (string, error) {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return "", err
+	}
+	sync, err := newInstanceSync(syncSource, ociUrl, ociTag, ociPullSecret)
+	if err != nil {
+		return "", err
+	}
+	syncSource, ociTag = sync.source, sync.tag
 
-	var results []string
+	report := newRunReport("bootstrap")
+	var ageKeyPairResult string
 
 	// =========================================================================
 	// Phase 0: Validate AGE Key Pair (delegated to secrets module)
 	// =========================================================================
 
+	start := time.Now()
 	if sopsAgeKey != nil && agePublicKey != nil {
 		msg, err := dag.Secrets().ValidateAgeKeyPair(ctx, sopsAgeKey, agePublicKey)
 		if err != nil {
-			return "", fmt.Errorf("phase 0: %w", err)
+			return report.render(outputFormat, report.failed(0, "ValidateAgeKeyPair", start, err))
 		}
-		results = append(results, fmt.Sprintf("Phase 0: %s", msg))
+		ageKeyPairResult = msg
+		report.ok(0, "ValidateAgeKeyPair", start, msg)
 	} else {
-		results = append(results, "Phase 0: Skipped (sopsAgeKey or agePublicKey not provided)")
+		report.skipped(0, "ValidateAgeKeyPair", "sopsAgeKey or agePublicKey not provided")
 	}
 
 	// =========================================================================
 	// Phase 1: Render Flux Instance Config (KCL)
 	// =========================================================================
 
-	kclParams := "name=flux,namespace=" + namespace + ",version=" + fluxVersion
-	if repository != "" {
-		kclParams += ",gitUrl=https://github.com/" + repository
-	}
-	if destinationPath != "" {
-		kclParams += ",gitPath=" + destinationPath
-	}
-	if gitRef != "" {
-		kclParams += ",gitRef=" + gitRef
-	}
-	if configParameters != "" {
-		kclParams += "," + configParameters
-	}
+	start = time.Now()
+	kclParams := instanceKclParams(namespace, fluxVersion, repository, destinationPath, gitRef, configParameters)
 
 	renderedContent, err := m.RenderConfig(
 		ctx, ociSource, kclParams, entrypoint, renderSecrets,
 		gitUsername, gitPassword, sopsAgeKey,
 	)
 	if err != nil {
-		return "", fmt.Errorf("phase 1: %w", err)
+		return report.render(outputFormat, report.failed(1, "RenderConfig", start, err))
 	}
 
-	docs := strings.Split(renderedContent, "---\n")
-	var secretDocs []string
-	var configDocs []string
+	configDocs, secretDocs, err := splitRenderedDocs(renderedContent)
+	if err != nil {
+		return report.render(outputFormat, report.failed(1, "RenderConfig", start, fmt.Errorf("parse rendered manifests: %w", err)))
+	}
 
-	for _, doc := range docs {
-		trimmed := strings.TrimSpace(doc)
-		if trimmed == "" {
-			continue
-		}
-		if strings.Contains(doc, "kind: Secret") {
-			secretDocs = append(secretDocs, doc)
-		} else {
-			configDocs = append(configDocs, doc)
+	if configDocs, err = sync.apply(configDocs); err != nil {
+		return report.render(outputFormat, report.failed(1, "RenderConfig", start, err))
+	}
+
+	if plan {
+		out, err := buildBootstrapPlan(bootstrapPlanInput{
+			namespace:        namespace,
+			kclParams:        kclParams,
+			configDocs:       configDocs,
+			secretDocs:       secretDocs,
+			ageKeyPairResult: ageKeyPairResult,
+			haveAgePublicKey: agePublicKey != nil,
+			repository:       repository,
+			branchName:       branchName,
+			baseBranch:       baseBranch,
+			createPR:         createPR,
+			mergePR:          mergePR,
+			mergeMethod:      mergeMethod,
+			destinationPath:  destinationPath,
+			haveGitToken:     gitToken != nil,
+			encryptSecrets:   encryptSecrets,
+			commitToGit:      commitToGit,
+			deployOperator:   deployOperator,
+			applyConfig:      applyConfig,
+			applySecrets:     applySecrets,
+			waitForReconcile: waitForReconciliation,
+			operatorVersion:  operatorVersion,
+			syncSource:       syncSource,
+			ociURL:           ociUrl,
+			ociTag:           ociTag,
+			pushArtifact:     pushArtifact,
+			helmfileRef:      helmfileRef,
+		})
+		if err != nil {
+			return "", fmt.Errorf("plan: %w", err)
 		}
+		return out, nil
 	}
 
 	var paramKeys []string
@@ -304,18 +280,23 @@ func (m *Flux) Bootstrap(
 			paramKeys = append(paramKeys, parts[0])
 		}
 	}
-	results = append(results, fmt.Sprintf("Phase 1: KCL parameter keys: %v — rendered %d config doc(s) and %d secret doc(s)", paramKeys, len(configDocs), len(secretDocs)))
+	report.ok(1, "RenderConfig", start,
+		fmt.Sprintf("KCL parameter keys: %v — rendered %d config doc(s) and %d secret doc(s)", paramKeys, len(configDocs), len(secretDocs)),
+		fmt.Sprintf("config.yaml (%d doc(s))", len(configDocs)),
+		fmt.Sprintf("secrets.yaml (%d doc(s))", len(secretDocs)),
+	)
 
 	// =========================================================================
 	// Phase 2: Encrypt Secrets with SOPS (delegated to secrets module)
 	// =========================================================================
 
-	secretContent := strings.Join(secretDocs, "---\n")
+	start = time.Now()
+	secretContent := joinManifests(secretDocs)
 	var secretsForCommit string // pragma: allowlist secret
 
 	if encryptSecrets && len(secretDocs) > 0 {
 		if agePublicKey == nil {
-			return "", fmt.Errorf("phase 2: encryptSecrets=true but agePublicKey is nil") // pragma: allowlist secret
+			return report.render(outputFormat, report.failed(2, "EncryptSecrets", start, fmt.Errorf("encryptSecrets=true but agePublicKey is nil"))) // pragma: allowlist secret
 		}
 
 		encrypted, err := dag.Secrets().EncryptString( // pragma: allowlist secret
@@ -328,110 +309,136 @@ func (m *Flux) Bootstrap(
 			},
 		)
 		if err != nil {
-			return "", fmt.Errorf("phase 2: %w", err)
+			return report.render(outputFormat, report.failed(2, "EncryptSecrets", start, err))
 		}
 		secretsForCommit = encrypted // pragma: allowlist secret
-		results = append(results, "Phase 2: Secrets encrypted with SOPS")
+		report.ok(2, "EncryptSecrets", start, "Secrets encrypted with SOPS", "secrets.yaml (SOPS-encrypted)")
 	} else if len(secretDocs) > 0 {
 		secretsForCommit = secretContent // pragma: allowlist secret
-		results = append(results, "Phase 2: Skipped encryption (encryptSecrets=false)")
+		report.skipped(2, "EncryptSecrets", "encryptSecrets=false")
 	} else {
-		results = append(results, "Phase 2: Skipped (no secrets to encrypt)")
+		report.skipped(2, "EncryptSecrets", "no secrets to encrypt")
 	}
 
 	// =========================================================================
-	// Phase 3: Commit to Git
+	// Phase 3: Commit to Git (or push the OCI artifact)
 	// =========================================================================
 
-	if commitToGit {
+	start = time.Now()
+	if syncSource == "oci" {
+		if pushArtifact {
+			ref, err := m.PushConfigArtifact(ctx, joinManifests(configDocs), ociUrl, ociTag, secretsForCommit,
+				"", "", registryUsername, registryPassword, nil, false, fluxCliImage)
+			if err != nil {
+				return report.render(outputFormat, report.failed(3, "PushConfigArtifact", start, err))
+			}
+			report.ok(3, "PushConfigArtifact", start, "Pushed config artifact "+ref, ref)
+		} else {
+			report.skipped(3, "PushConfigArtifact", "pushArtifact=false")
+		}
+	} else if commitToGit {
 		if repository == "" {
-			return "", fmt.Errorf("phase 3: commitToGit=true but repository is empty")
+			return report.render(outputFormat, report.failed(3, "CommitConfig", start, fmt.Errorf("commitToGit=true but repository is empty")))
 		}
 		if gitToken == nil {
-			return "", fmt.Errorf("phase 3: commitToGit=true but gitToken is nil")
+			return report.render(outputFormat, report.failed(3, "CommitConfig", start, fmt.Errorf("commitToGit=true but gitToken is nil")))
 		}
 
-		configContent := strings.Join(configDocs, "---\n")
-		msg, err := m.CommitConfig(ctx, configContent, repository, branchName, destinationPath, gitToken, secretsForCommit)
+		configContent := joinManifests(configDocs)
+		msg, err := m.CommitConfig(ctx, configContent, repository, branchName, destinationPath, gitToken, secretsForCommit,
+			"", createPR, baseBranch,
+			fmt.Sprintf("Flux bootstrap: %s", strings.TrimSuffix(destinationPath, "/")), "",
+			mergePR, mergeMethod)
 		if err != nil {
-			return "", fmt.Errorf("phase 3: %w", err)
+			return report.render(outputFormat, report.failed(3, "CommitConfig", start, err))
+		}
+		artifacts := []string{fmt.Sprintf("https://github.com/%s/tree/%s/%s", repository, branchName, strings.TrimSuffix(destinationPath, "/"))}
+		for _, line := range strings.Split(msg, "\n") {
+			if url, ok := strings.CutPrefix(line, "Opened PR: "); ok {
+				artifacts = append(artifacts, url)
+			}
 		}
-		results = append(results, fmt.Sprintf("Phase 3: %s", msg))
+		report.ok(3, "CommitConfig", start, msg, artifacts...)
 	} else {
-		results = append(results, "Phase 3: Skipped (commitToGit=false)")
+		report.skipped(3, "CommitConfig", "commitToGit=false")
 	}
 
 	// =========================================================================
 	// Phase 4: Deploy Flux Operator via Helmfile
 	// =========================================================================
 
+	start = time.Now()
 	if deployOperator {
 		err := m.DeployOperator(ctx, kubeConfig, helmfileRef, src, "version="+operatorVersion)
 		if err != nil {
-			return "", fmt.Errorf("phase 4: deploy flux operator: %w", err)
+			return report.render(outputFormat, report.failed(4, "DeployOperator", start, fmt.Errorf("deploy flux operator: %w", err)))
 		}
-		results = append(results, "Phase 4: Flux operator deployed via Helmfile")
+		report.ok(4, "DeployOperator", start, "Flux operator deployed via Helmfile")
 	} else {
-		results = append(results, "Phase 4: Skipped (deployOperator=false)")
+		report.skipped(4, "DeployOperator", "deployOperator=false")
 	}
 
 	// =========================================================================
 	// Phase 5: Apply Rendered Config to Cluster
 	// =========================================================================
 
+	start = time.Now()
 	if applyConfig && len(configDocs) > 0 {
-		configContent := strings.Join(configDocs, "---\n")
+		configContent := joinManifests(configDocs)
 		msg, err := m.ApplyConfig(ctx, configContent, namespace, kubeConfig)
 		if err != nil {
-			return "", fmt.Errorf("phase 5: %w", err)
+			return report.render(outputFormat, report.failed(5, "ApplyConfig", start, err))
 		}
-		results = append(results, fmt.Sprintf("Phase 5: %s", msg))
+		report.ok(5, "ApplyConfig", start, msg)
 	} else {
-		results = append(results, "Phase 5: Skipped (applyConfig=false or no config docs)")
+		report.skipped(5, "ApplyConfig", "applyConfig=false or no config docs")
 	}
 
 	// =========================================================================
 	// Phase 6: Apply Secrets to Cluster (AFTER operator is running)
 	// =========================================================================
 
+	start = time.Now()
 	if applySecrets && len(secretDocs) > 0 {
 		msg, err := m.ApplySecrets(ctx, secretContent, namespace, kubeConfig)
 		if err != nil {
-			return "", fmt.Errorf("phase 6: %w", err)
+			return report.render(outputFormat, report.failed(6, "ApplySecrets", start, err))
 		}
-		results = append(results, fmt.Sprintf("Phase 6: %s", msg))
+		report.ok(6, "ApplySecrets", start, msg)
 	} else {
-		results = append(results, "Phase 6: Skipped (applySecrets=false or no secrets)")
+		report.skipped(6, "ApplySecrets", "applySecrets=false or no secrets")
 	}
 
 	// =========================================================================
 	// Phase 7: Verify Secrets Exist
 	// =========================================================================
 
+	start = time.Now()
 	if applySecrets && len(secretDocs) > 0 {
 		msg, err := m.VerifySecrets(ctx, secretContent, namespace, kubeConfig)
 		if err != nil {
-			results = append(results, fmt.Sprintf("Phase 7: Warning — %v", err))
+			report.warning(7, "VerifySecrets", start, err)
 		} else {
-			results = append(results, fmt.Sprintf("Phase 7: %s", msg))
+			report.ok(7, "VerifySecrets", start, msg)
 		}
 	} else {
-		results = append(results, "Phase 7: Skipped (no secrets to verify)")
+		report.skipped(7, "VerifySecrets", "no secrets to verify")
 	}
 
 	// =========================================================================
 	// Phase 8: Wait for Reconciliation (Flux CLI)
 	// =========================================================================
 
+	start = time.Now()
 	if waitForReconciliation {
 		msg, err := m.WaitForReconciliation(ctx, namespace, kubeConfig, reconciliationTimeout, fluxCliImage)
 		if err != nil {
-			return "", fmt.Errorf("phase 8: %w", err)
+			return report.render(outputFormat, report.failed(8, "WaitForReconciliation", start, err))
 		}
-		results = append(results, fmt.Sprintf("Phase 8: %s", msg))
+		report.ok(8, "WaitForReconciliation", start, msg)
 	} else {
-		results = append(results, "Phase 8: Skipped (waitForReconciliation=false)")
+		report.skipped(8, "WaitForReconciliation", "waitForReconciliation=false")
 	}
 
-	return strings.Join(results, "\n"), nil
+	return report.render(outputFormat, nil)
 }
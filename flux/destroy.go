@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"dagger/flux/internal/dagger"
+)
+
+// fluxCRDPattern matches the CRDs owned by Flux and the Flux operator.
+const fluxCRDPattern = `\.(toolkit\.fluxcd\.io|fluxcd\.controlplane\.io)$`
+
+// destroyInventory is what Destroy finds on the cluster before removing
+// anything. It doubles as the --preview output. SecretsSkipped lists the
+// Helm release and service-account token Secrets ("<name> (<type>)"):
+// Phase 4 leaves them alone because the Helmfile destroy in Phase 5 needs
+// the release records; they go with the release or the namespace.
+type destroyInventory struct {
+	Namespace         string   `json:"namespace"`
+	FluxInstances     []string `json:"fluxInstances"`
+	Kustomizations    []string `json:"kustomizations"`
+	HelmReleases      []string `json:"helmReleases"`
+	Finalizers        []string `json:"finalizers"`
+	SecretsToDelete   []string `json:"secretsToDelete"`
+	SecretsPreserved  []string `json:"secretsPreserved"`
+	SecretsSkipped    []string `json:"secretsSkipped"`
+	OperatorUninstall bool     `json:"operatorUninstall"`
+	DeleteNamespace   bool     `json:"deleteNamespace"`
+	Reason            string   `json:"reason,omitempty"`
+}
+
+// Destroy tears down Flux from a cluster without garbage-collecting the
+// workloads Flux deployed.
+//
+// Phase order:
+//
+//	0: Inventory — list Flux objects and secrets (--preview stops here)
+//	1: Suspend all Kustomizations and HelmReleases
+//	2: Remove finalizers from Flux toolkit objects so nothing is pruned
+//	   and nothing blocks once the controllers are gone
+//	3: Delete FluxInstance CR
+//	4: Delete Flux secrets (except --preserve-secrets, Helm release and
+//	   service-account token secrets — Phase 5 needs the release records)
+//	5: Uninstall Flux operator (Helmfile destroy)
+//	6: Delete flux-system namespace, detecting and (optionally) clearing
+//	   finalizers that keep it in Terminating
+//
+// The namespace is kept whenever --preserve-secrets is set, even if the
+// named secrets were not found. Inventory fails on kubectl errors.
+//
+// Usage:
+//
+//	dagger call -m flux destroy --kube-config file:///tmp/kubeconfig
+//	dagger call -m flux destroy --kube-config file:///tmp/kubeconfig --preview
+func (m *Flux) Destroy(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// Target namespace
+	// +optional
+	// +default="flux-system"
+	namespace string,
+	// Helmfile reference for Flux operator
+	// +optional
+	// +default="helmfile.yaml"
+	helmfileRef string,
+	// Directory containing the helmfile
+	// +optional
+	src *dagger.Directory,
+	// Flux operator version for Helmfile state values
+	// +optional
+	// +default="0.42.1"
+	operatorVersion string,
+	// Output format: "text" (Phase N lines) or "json" (per-phase status,
+	// duration, error and artifacts)
+	// +optional
+	// +default="text"
+	outputFormat string,
+	// Only list what would be removed (JSON); change nothing
+	// +optional
+	// +default=false
+	preview bool,
+	// Comma-separated secret names in the namespace to keep (e.g. "sops-age")
+	// +optional
+	preserveSecrets string,
+	// Suspend all Kustomizations and HelmReleases before removal
+	// +optional
+	// +default=true
+	suspend bool,
+	// Remove finalizers that block FluxInstance or namespace deletion
+	// +optional
+	// +default=true
+	removeFinalizers bool,
+	// How long to wait for the FluxInstance and namespace to disappear
+	// +optional
+	// +default="2m"
+	deleteTimeout string,
+) (string, error) {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return "", err
+	}
+
+	report := newRunReport("destroy")
+	timeoutSecs := parseTimeout(deleteTimeout)
+
+	preserved := map[string]bool{}
+	for _, name := range strings.Split(preserveSecrets, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			preserved[name] = true
+		}
+	}
+
+	// =========================================================================
+	// Phase 0: Inventory
+	// =========================================================================
+
+	start := time.Now()
+	inv, err := readDestroyInventory(ctx, kubeConfig, namespace, preserved)
+	if err != nil {
+		return report.render(outputFormat, report.failed(0, "Inventory", start, err))
+	}
+
+	if preview {
+		data, err := json.MarshalIndent(inv, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("preview: marshal inventory: %w", err)
+		}
+		return string(data), nil
+	}
+
+	report.ok(0, "Inventory", start, fmt.Sprintf("%d FluxInstance(s), %d Kustomization(s), %d HelmRelease(s), %d secret(s) to delete, %d preserved, %d Helm/service-account secret(s) skipped",
+		len(inv.FluxInstances), len(inv.Kustomizations), len(inv.HelmReleases), len(inv.SecretsToDelete), len(inv.SecretsPreserved), len(inv.SecretsSkipped)))
+
+	// =========================================================================
+	// Phase 1: Suspend Kustomizations and HelmReleases
+	// =========================================================================
+
+	start = time.Now()
+	switch {
+	case !suspend:
+		report.skipped(1, "Suspend", "suspend=false")
+	case len(inv.Kustomizations)+len(inv.HelmReleases) == 0:
+		report.skipped(1, "Suspend", "no Kustomizations or HelmReleases")
+	default:
+		var b strings.Builder
+		b.WriteString("set -e\n")
+		for _, ref := range inv.Kustomizations {
+			ns, name, _ := strings.Cut(ref, "/")
+			fmt.Fprintf(&b, "kubectl patch kustomizations.kustomize.toolkit.fluxcd.io %s -n %s --type=merge -p '{\"spec\":{\"suspend\":true}}'\n", name, ns)
+		}
+		for _, ref := range inv.HelmReleases {
+			ns, name, _ := strings.Cut(ref, "/")
+			fmt.Fprintf(&b, "kubectl patch helmreleases.helm.toolkit.fluxcd.io %s -n %s --type=merge -p '{\"spec\":{\"suspend\":true}}'\n", name, ns)
+		}
+		if _, err := kubectlContainer(kubeConfig).WithExec([]string{"sh", "-c", b.String()}).Stdout(ctx); err != nil {
+			return report.render(outputFormat, report.failed(1, "Suspend", start, fmt.Errorf("suspend: %w", err)))
+		}
+		report.ok(1, "Suspend", start, fmt.Sprintf("Suspended %d Kustomization(s) and %d HelmRelease(s)", len(inv.Kustomizations), len(inv.HelmReleases)))
+	}
+
+	// =========================================================================
+	// Phase 2: Remove finalizers from Flux toolkit objects
+	// =========================================================================
+
+	start = time.Now()
+	if !removeFinalizers {
+		report.skipped(2, "RemoveFinalizers", "removeFinalizers=false")
+	} else {
+		out, err := kubectlContainer(kubeConfig).
+			WithExec([]string{"sh", "-c", removeFluxFinalizersScript}).
+			Stdout(ctx)
+		if err != nil {
+			report.warning(2, "RemoveFinalizers", start, fmt.Errorf("remove finalizers: %w", err))
+		} else {
+			cleared := nonEmptyLines(out)
+			report.ok(2, "RemoveFinalizers", start, fmt.Sprintf("Removed finalizers from %d Flux object(s)", len(cleared)), cleared...)
+		}
+	}
+
+	// =========================================================================
+	// Phase 3: Delete FluxInstance CR
+	// =========================================================================
+
+	start = time.Now()
+	out, err := kubectlContainer(kubeConfig).
+		WithExec([]string{"sh", "-c", deleteFluxInstanceScript(namespace, timeoutSecs, removeFinalizers)}).
+		Stdout(ctx)
+	switch {
+	case err != nil:
+		report.warning(3, "DeleteFluxInstance", start, fmt.Errorf("delete FluxInstance: %w", err))
+	case strings.Contains(out, "@@stuck"):
+		report.warning(3, "DeleteFluxInstance", start, fmt.Errorf("FluxInstance still present after %ds (finalizers not removed)", timeoutSecs))
+	case strings.Contains(out, "@@forced"):
+		report.ok(3, "DeleteFluxInstance", start, "FluxInstance CRs deleted (stuck finalizer removed)")
+	default:
+		report.ok(3, "DeleteFluxInstance", start, "FluxInstance CRs deleted")
+	}
+
+	// =========================================================================
+	// Phase 4: Delete Flux secrets (except preserved)
+	// =========================================================================
+
+	start = time.Now()
+	if len(inv.SecretsToDelete) == 0 {
+		report.skipped(4, "DeleteSecrets", "no secrets to delete")
+	} else {
+		args := append([]string{"kubectl", "delete", "secret", "-n", namespace, "--ignore-not-found=true"}, inv.SecretsToDelete...)
+		if _, err := kubectlContainer(kubeConfig).WithExec(args).Stdout(ctx); err != nil {
+			report.warning(4, "DeleteSecrets", start, fmt.Errorf("delete secrets: %w", err))
+		} else {
+			msg := fmt.Sprintf("Deleted %d secret(s)", len(inv.SecretsToDelete))
+			if len(inv.SecretsPreserved) > 0 {
+				msg += fmt.Sprintf(", preserved %s", strings.Join(inv.SecretsPreserved, ", "))
+			}
+			report.ok(4, "DeleteSecrets", start, msg)
+		}
+	}
+
+	// =========================================================================
+	// Phase 5: Uninstall Flux operator (Helmfile destroy)
+	// =========================================================================
+
+	start = time.Now()
+	err = dag.Helm().HelmfileOperation(
+		ctx,
+		dagger.HelmHelmfileOperationOpts{
+			Src:             src,
+			HelmfileRef:     helmfileRef,
+			Operation:       "destroy",
+			KubeConfig:      kubeConfig,
+			StateValues:     "version=" + operatorVersion,
+			VaultAuthMethod: "approle",
+		},
+	)
+	if err != nil {
+		report.warning(5, "UninstallOperator", start, fmt.Errorf("helmfile destroy: %w", err))
+	} else {
+		report.ok(5, "UninstallOperator", start, "Flux operator uninstalled via Helmfile destroy")
+	}
+
+	// =========================================================================
+	// Phase 6: Delete namespace
+	// =========================================================================
+
+	start = time.Now()
+	if !inv.DeleteNamespace {
+		report.skipped(6, "DeleteNamespace", inv.Reason)
+		return report.render(outputFormat, nil)
+	}
+
+	out, err = kubectlContainer(kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", deleteNamespaceScript(namespace, timeoutSecs, removeFinalizers)}).
+		Stdout(ctx)
+	if err != nil {
+		return report.render(outputFormat, report.failed(6, "DeleteNamespace", start, fmt.Errorf("delete namespace: %w", err)))
+	}
+
+	var stuck, forced []string
+	for _, line := range nonEmptyLines(out) {
+		switch {
+		case strings.HasPrefix(line, "@@stuck "):
+			stuck = append(stuck, strings.TrimPrefix(line, "@@stuck "))
+		case strings.HasPrefix(line, "@@forced "):
+			forced = append(forced, strings.TrimPrefix(line, "@@forced "))
+		}
+	}
+	if strings.Contains(out, "@@ns deleted") {
+		msg := fmt.Sprintf("Namespace %s deleted", namespace)
+		if len(forced) > 0 {
+			msg += fmt.Sprintf(" (cleared finalizers on %d object(s))", len(forced))
+		}
+		report.ok(6, "DeleteNamespace", start, msg, forced...)
+		return report.render(outputFormat, nil)
+	}
+
+	return report.render(outputFormat, report.failed(6, "DeleteNamespace", start,
+		fmt.Errorf("namespace %s stuck in Terminating after %ds; objects with finalizers: %s",
+			namespace, timeoutSecs, strings.Join(stuck, ", "))))
+}
+
+// readDestroyInventory lists the Flux objects and namespace secrets Destroy
+// would touch.
+func readDestroyInventory(ctx context.Context, kubeConfig *dagger.Secret, namespace string, preserved map[string]bool) (destroyInventory, error) {
+	// A missing CRD lists as empty (Flux already partly removed); any other
+	// kubectl error fails the inventory, so an unreadable cluster is never
+	// mistaken for an empty one.
+	script := fmt.Sprintf(`set -e
+list() {
+  tag=$1; shift
+  if kubectl get "$@" --no-headers >/tmp/list.out 2>/tmp/list.err; then
+    sed "s/^/@@$tag /" /tmp/list.out
+  elif grep -q "the server doesn't have a resource type" /tmp/list.err; then
+    :
+  else
+    echo "list $tag: $(cat /tmp/list.err)" >&2
+    exit 1
+  fi
+}
+list fi fluxinstances.fluxcd.controlplane.io -n %[1]s -o custom-columns=NAME:.metadata.name
+list ks kustomizations.kustomize.toolkit.fluxcd.io -A -o custom-columns=NS:.metadata.namespace,NAME:.metadata.name
+list hr helmreleases.helm.toolkit.fluxcd.io -A -o custom-columns=NS:.metadata.namespace,NAME:.metadata.name
+for crd in $(kubectl get crd -o name 2>/dev/null | sed 's#^customresourcedefinition.apiextensions.k8s.io/##' | grep -E '%[2]s' || true); do
+  kubectl get "$crd" -A --no-headers -o custom-columns=NS:.metadata.namespace,NAME:.metadata.name,FIN:.metadata.finalizers 2>/dev/null |
+    awk -v crd="$crd" '$3 != "<none>" { print "@@fin " crd " " $1 "/" $2 }'
+done
+list secret secrets -n %[1]s -o custom-columns=NAME:.metadata.name,TYPE:.type
+`, namespace, fluxCRDPattern)
+
+	out, err := kubectlContainer(kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return destroyInventory{}, fmt.Errorf("inventory: %w", err)
+	}
+
+	inv := destroyInventory{
+		Namespace:         namespace,
+		FluxInstances:     []string{},
+		Kustomizations:    []string{},
+		HelmReleases:      []string{},
+		Finalizers:        []string{},
+		SecretsToDelete:   []string{},
+		SecretsPreserved:  []string{},
+		SecretsSkipped:    []string{},
+		OperatorUninstall: true,
+		DeleteNamespace:   true,
+	}
+	for _, line := range nonEmptyLines(out) {
+		fields := strings.Fields(line)
+		switch {
+		case fields[0] == "@@fi" && len(fields) == 2:
+			inv.FluxInstances = append(inv.FluxInstances, fields[1])
+		case fields[0] == "@@ks" && len(fields) == 3:
+			inv.Kustomizations = append(inv.Kustomizations, fields[1]+"/"+fields[2])
+		case fields[0] == "@@hr" && len(fields) == 3:
+			inv.HelmReleases = append(inv.HelmReleases, fields[1]+"/"+fields[2])
+		case fields[0] == "@@fin" && len(fields) == 3:
+			inv.Finalizers = append(inv.Finalizers, fields[1]+" "+fields[2])
+		case fields[0] == "@@secret" && len(fields) == 3:
+			switch {
+			case driftIgnoredSecretTypes[fields[2]]:
+				inv.SecretsSkipped = append(inv.SecretsSkipped, fmt.Sprintf("%s (%s)", fields[1], fields[2]))
+			case preserved[fields[1]]:
+				inv.SecretsPreserved = append(inv.SecretsPreserved, fields[1])
+			default:
+				inv.SecretsToDelete = append(inv.SecretsToDelete, fields[1])
+			}
+		}
+	}
+	// Keep the namespace whenever secrets are to be preserved, whether or
+	// not they showed up in the listing.
+	if len(preserved) > 0 {
+		names := make([]string, 0, len(preserved))
+		for name := range preserved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		inv.DeleteNamespace = false
+		inv.Reason = fmt.Sprintf("namespace kept for preserved secret(s): %s", strings.Join(names, ", "))
+	}
+	return inv, nil
+}
+
+// removeFluxFinalizersScript clears metadata.finalizers on every Flux
+// toolkit object (FluxInstances excluded — the operator must finalize them
+// to uninstall the controllers) and prints each cleared object.
+var removeFluxFinalizersScript = fmt.Sprintf(`set -e
+for crd in $(kubectl get crd -o name | sed 's#^customresourcedefinition.apiextensions.k8s.io/##' | grep -E '%s' | grep -v '^fluxinstances\.' || true); do
+  kubectl get "$crd" -A --no-headers -o custom-columns=NS:.metadata.namespace,NAME:.metadata.name,FIN:.metadata.finalizers 2>/dev/null |
+  while read ns name fin; do
+    [ "$fin" = "<none>" ] && continue
+    kubectl patch "$crd" "$name" -n "$ns" --type=merge -p '{"metadata":{"finalizers":null}}' >/dev/null
+    echo "$crd $ns/$name"
+  done
+done
+`, fluxCRDPattern)
+
+// deleteFluxInstanceScript deletes all FluxInstances and waits for them to
+// go away. When they are still present after timeoutSecs and
+// removeFinalizers is set, their finalizers are cleared.
+func deleteFluxInstanceScript(namespace string, timeoutSecs int, removeFinalizers bool) string {
+	force := `echo "@@stuck"`
+	if removeFinalizers {
+		force = fmt.Sprintf(`for fi in $(kubectl get fluxinstances.fluxcd.controlplane.io -n %[1]s -o name); do
+    kubectl patch "$fi" -n %[1]s --type=merge -p '{"metadata":{"finalizers":null}}' >/dev/null
+  done
+  echo "@@forced"`, namespace)
+	}
+	return fmt.Sprintf(`set -e
+kubectl get crd fluxinstances.fluxcd.controlplane.io >/dev/null 2>&1 || exit 0
+kubectl delete fluxinstances.fluxcd.controlplane.io --all -n %[1]s --ignore-not-found=true --wait=false
+if ! kubectl wait fluxinstances.fluxcd.controlplane.io --all -n %[1]s --for=delete --timeout=%[2]ds >/dev/null 2>&1; then
+  %[3]s
+fi
+`, namespace, timeoutSecs, force)
+}
+
+// deleteNamespaceScript deletes the namespace and waits for it to vanish.
+// If it is stuck in Terminating, every object still carrying finalizers is
+// reported (@@stuck) and — with removeFinalizers — cleared (@@forced)
+// before waiting once more.
+func deleteNamespaceScript(namespace string, timeoutSecs int, removeFinalizers bool) string {
+	clearFinalizers := "false"
+	if removeFinalizers {
+		clearFinalizers = "true"
+	}
+	return fmt.Sprintf(`set +e
+NS=%[1]s
+kubectl delete namespace "$NS" --ignore-not-found=true --wait=false >/dev/null
+if kubectl wait namespace "$NS" --for=delete --timeout=%[2]ds >/dev/null 2>&1 || ! kubectl get namespace "$NS" >/dev/null 2>&1; then
+  echo "@@ns deleted"; exit 0
+fi
+for r in $(kubectl api-resources --verbs=list --namespaced -o name 2>/dev/null); do
+  kubectl get "$r" -n "$NS" --no-headers -o custom-columns=NAME:.metadata.name,FIN:.metadata.finalizers 2>/dev/null |
+  while read name fin; do
+    [ -z "$name" ] || [ "$fin" = "<none>" ] && continue
+    if [ "%[3]s" = "true" ]; then
+      kubectl patch "$r" "$name" -n "$NS" --type=merge -p '{"metadata":{"finalizers":null}}' >/dev/null && echo "@@forced $r/$name"
+    else
+      echo "@@stuck $r/$name $fin"
+    fi
+  done
+done
+if [ "%[3]s" = "true" ] && kubectl wait namespace "$NS" --for=delete --timeout=%[2]ds >/dev/null 2>&1; then
+  echo "@@ns deleted"; exit 0
+fi
+kubectl get namespace "$NS" >/dev/null 2>&1 || { echo "@@ns deleted"; exit 0; }
+kubectl get namespace "$NS" -o jsonpath='{range .status.conditions[*]}{"@@stuck "}{.type}: {.message}{"\n"}{end}'
+`, namespace, timeoutSecs, clearFinalizers)
+}
+
+// nonEmptyLines splits s into trimmed, non-empty lines.
+func nonEmptyLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/flux/internal/dagger"
+)
+
+// diagnosticsEventKinds are the involvedObject kinds whose events are
+// collected.
+var diagnosticsEventKinds = []string{
+	"FluxInstance", "GitRepository", "OCIRepository", "HelmRepository", "HelmChart",
+	"Bucket", "Kustomization", "HelmRelease",
+}
+
+// CollectDiagnostics gathers a troubleshooting bundle from a cluster with
+// a broken or stuck Flux installation and returns it as a directory:
+//
+//	summary.txt            collection time, namespace, per-command status
+//	pods.txt               pods in the Flux namespace
+//	logs/<deployment>.log  controller and operator logs
+//	events.txt             events of all Flux objects (all namespaces)
+//	flux-get-all.txt       flux get all -A
+//	flux-check.txt         flux check
+//	fluxinstance.yaml      FluxInstance objects including status
+//	inventory.txt          FluxInstance inventory (object id and version)
+//	helm-status.txt        Helm status of the operator release
+//	secrets.json           Secrets in the namespace, data values redacted
+//
+// Commands that fail are recorded in summary.txt; the bundle is always
+// returned. Export it and attach it to the failed CI run.
+func (m *Flux) CollectDiagnostics(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// Flux namespace
+	// +optional
+	// +default="flux-system"
+	namespace string,
+	// Helm release name of the Flux operator
+	// +optional
+	// +default="flux-operator"
+	operatorRelease string,
+	// Log lines per container
+	// +optional
+	// +default=500
+	logLines int,
+	// Flux CLI container image
+	// +optional
+	// +default="ghcr.io/fluxcd/flux-cli:v2.8.5"
+	fluxCliImage string,
+) (*dagger.Directory, error) {
+	cacheBuster := time.Now().UTC().Format(time.RFC3339Nano)
+
+	// alpine/k8s ships kubectl and helm; every command records its exit
+	// status instead of aborting so a partial bundle is still returned.
+	var b strings.Builder
+	fmt.Fprintf(&b, `mkdir -p /diag/logs
+run() { out="$1"; shift; if "$@" > "/diag/$out" 2>&1; then echo "ok      $out"; else echo "failed  $out (exit $?)"; fi; }
+run pods.txt kubectl get pods -n %[1]s -o wide
+for d in $(kubectl get deployments -n %[1]s -o name 2>/dev/null); do
+  run "logs/${d#deployment.apps/}.log" kubectl logs -n %[1]s "$d" --all-containers --tail=%[2]d
+done
+: > /diag/events.txt
+for k in %[3]s; do
+  echo "### $k" >> /diag/events.txt
+  kubectl get events -A --field-selector involvedObject.kind=$k --sort-by=.lastTimestamp >> /diag/events.txt 2>&1 || echo "failed  events.txt ($k)"
+done
+run fluxinstance.yaml kubectl get fluxinstances.fluxcd.controlplane.io -n %[1]s -o yaml
+run inventory.txt kubectl get fluxinstances.fluxcd.controlplane.io -n %[1]s -o jsonpath='{range .items[*].status.inventory.entries[*]}{.id}{"\t"}{.v}{"\n"}{end}'
+run helm-status.txt helm status %[4]s -n %[1]s
+kubectl get secrets -n %[1]s -o json > /tmp/secrets.json 2>/dev/null || echo '{"items":[]}' > /tmp/secrets.json
+`, namespace, logLines, strings.Join(diagnosticsEventKinds, " "), operatorRelease)
+
+	collector := dag.Container().
+		From("alpine/k8s:1.31.0").
+		WithMountedSecret("/tmp/kubeconfig", kubeConfig, dagger.ContainerWithMountedSecretOpts{
+			Mode: 0444,
+		}).
+		WithEnvVariable("KUBECONFIG", "/tmp/kubeconfig").
+		WithEnvVariable("CACHE_BUSTER", cacheBuster).
+		WithExec([]string{"sh", "-c", b.String()})
+
+	collectStatus, err := collector.Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("collect-diagnostics: %w", err)
+	}
+
+	rawSecrets, err := collector.File("/tmp/secrets.json").Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("collect-diagnostics: read secrets: %w", err)
+	}
+	redacted, err := redactSecretList(rawSecrets)
+	if err != nil {
+		return nil, fmt.Errorf("collect-diagnostics: %w", err)
+	}
+
+	cli := fluxCliContainer(fluxCliImage, kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", cacheBuster).
+		WithExec([]string{"sh", "-c", `mkdir -p /diag
+run() { out="$1"; shift; if "$@" > "/diag/$out" 2>&1; then echo "ok      $out"; else echo "failed  $out (exit $?)"; fi; }
+run flux-get-all.txt flux get all -A
+run flux-check.txt flux check
+`})
+	cliStatus, err := cli.Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("collect-diagnostics: %w", err)
+	}
+
+	summary := fmt.Sprintf("Flux diagnostics\ncollected: %s\nnamespace: %s\n\n%s%s",
+		time.Now().UTC().Format(time.RFC3339), namespace, collectStatus, cliStatus)
+
+	return collector.Directory("/diag").
+		WithDirectory(".", cli.Directory("/diag")).
+		WithNewFile("secrets.json", redacted).
+		WithNewFile("summary.txt", summary), nil
+}
+
+// redactSecretList replaces every data and stringData value of a
+// `kubectl get secrets -o json` list and drops the last-applied
+// annotation, which carries the values too. Keys and value sizes are kept
+// so missing or empty keys are still visible.
+func redactSecretList(raw string) (string, error) {
+	var list map[string]any
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return "", fmt.Errorf("parse secrets: %w", err)
+	}
+
+	items, _ := list["items"].([]any)
+	for _, item := range items {
+		secret, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, field := range []string{"data", "stringData"} {
+			values, ok := secret[field].(map[string]any)
+			if !ok {
+				continue
+			}
+			for k, v := range values {
+				s, _ := v.(string)
+				values[k] = fmt.Sprintf("REDACTED (%d chars)", len(s))
+			}
+		}
+		if meta, ok := secret["metadata"].(map[string]any); ok {
+			if annotations, ok := meta["annotations"].(map[string]any); ok {
+				delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal redacted secrets: %w", err)
+	}
+	return string(data), nil
+}
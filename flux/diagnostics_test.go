@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactSecretList(t *testing.T) {
+	raw := `{"apiVersion":"v1","kind":"List","items":[{
+  "apiVersion":"v1","kind":"Secret",
+  "metadata":{"name":"git-token","namespace":"flux-system","annotations":{
+    "kubectl.kubernetes.io/last-applied-configuration":"{\"stringData\":{\"password\":\"s3cr3t\"}}",
+    "owner":"flux"}},
+  "type":"Opaque",
+  "data":{"password":"czNjcjN0","username":"Z2l0"},
+  "stringData":{"token":"plain-value"}}]}`
+
+	out, err := redactSecretList(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, leaked := range []string{"czNjcjN0", "Z2l0", "plain-value", "s3cr3t", "last-applied-configuration"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("redacted output still contains %q:\n%s", leaked, out)
+		}
+	}
+	for _, kept := range []string{`"password": "REDACTED (8 chars)"`, `"username"`, `"token"`, `"owner": "flux"`, `"git-token"`} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("expected %s in redacted output:\n%s", kept, out)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/flux/internal/dagger"
+)
+
+// driftObject is one entry of a driftReport.
+type driftObject struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	// Diff is the kubectl diff output; omitted for Secrets.
+	Diff string `json:"diff,omitempty"`
+	// Redacted is set when the object is a Secret and the diff was dropped.
+	Redacted bool   `json:"redacted,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// driftReport is the JSON document returned by DetectDrift.
+type driftReport struct {
+	Namespace string        `json:"namespace"`
+	Drifted   bool          `json:"drifted"`
+	Added     []driftObject `json:"added"`
+	Changed   []driftObject `json:"changed"`
+	Missing   []driftObject `json:"missing"`
+	InSync    []driftObject `json:"inSync"`
+	Errors    []driftObject `json:"errors,omitempty"`
+}
+
+// driftIgnoredSecretTypes are Secret types that live in the Flux namespace
+// but are never rendered by KCL, so they are not reported as added (and
+// Destroy does not delete them ahead of the Helmfile destroy).
+var driftIgnoredSecretTypes = map[string]bool{
+	"helm.sh/release.v1":                  true,
+	"kubernetes.io/service-account-token": true,
+}
+
+// DetectDrift renders the Flux instance config exactly like Bootstrap
+// (same KCL parameters, same config/secret split, same sync source) and
+// compares every
+// rendered object against the live cluster via `kubectl diff` (server-side
+// dry-run).
+//
+// Returns a JSON report with:
+//
+//	added:   live objects of a rendered kind that KCL does not render
+//	changed: rendered objects whose live state differs
+//	missing: rendered objects that do not exist on the cluster
+//	inSync:  rendered objects without differences
+//
+// Secret diffs are never included; changed Secrets are reported with
+// "redacted": true.
+//
+// Usage:
+//
+//	dagger call -m flux detect-drift \
+//	  --kube-config file:///tmp/kubeconfig \
+//	  --repository my-org/fleet --destination-path clusters/staging/
+func (m *Flux) DetectDrift(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// OCI KCL module source for rendering Flux instance config
+	// +optional
+	// +default="ghcr.io/stuttgart-things/kcl-flux-instance:0.3.3"
+	ociSource string,
+	// Additional comma-separated key=value pairs for KCL parameters
+	// +optional
+	configParameters string,
+	// Flux instance version
+	// +optional
+	// +default="2.8.5"
+	fluxVersion string,
+	// KCL entrypoint file name
+	// +optional
+	// +default="main.k"
+	entrypoint string,
+	// Whether KCL should also render Secret manifests
+	// +optional
+	// +default=false
+	renderSecrets bool,
+	// Git username for pull secret
+	// +optional
+	gitUsername *dagger.Secret,
+	// GitHub token for git pull secret
+	// +optional
+	gitPassword *dagger.Secret,
+	// AGE private key for SOPS decryption
+	// +optional
+	sopsAgeKey *dagger.Secret,
+	// Target namespace for Flux
+	// +optional
+	// +default="flux-system"
+	namespace string,
+	// Repository in "owner/repo" format
+	// +optional
+	repository string,
+	// Destination path within the repository
+	// +optional
+	// +default="clusters/"
+	destinationPath string,
+	// Git reference for Flux source (e.g., refs/heads/main)
+	// +optional
+	// +default="refs/heads/main"
+	gitRef string,
+	// FluxInstance sync source: "git" or "oci" (as passed to bootstrap)
+	// +optional
+	// +default="git"
+	syncSource string,
+	// OCI artifact repository for syncSource=oci
+	// +optional
+	ociUrl string,
+	// OCI artifact tag for syncSource=oci
+	// +optional
+	// +default="latest"
+	ociTag string,
+	// Pull secret the cluster uses for the OCI registry
+	// +optional
+	ociPullSecret string,
+	// Return an error (alongside the report) when drift is detected
+	// +optional
+	// +default=false
+	failOnDrift bool,
+) (string, error) {
+	sync, err := newInstanceSync(syncSource, ociUrl, ociTag, ociPullSecret)
+	if err != nil {
+		return "", fmt.Errorf("detect-drift: %w", err)
+	}
+	kclParams := instanceKclParams(namespace, fluxVersion, repository, destinationPath, gitRef, configParameters)
+
+	renderedContent, err := m.RenderConfig(
+		ctx, ociSource, kclParams, entrypoint, renderSecrets,
+		gitUsername, gitPassword, sopsAgeKey,
+	)
+	if err != nil {
+		return "", fmt.Errorf("detect-drift: %w", err)
+	}
+
+	configDocs, secretDocs, err := splitRenderedDocs(renderedContent)
+	if err != nil {
+		return "", fmt.Errorf("detect-drift: parse rendered manifests: %w", err)
+	}
+	if configDocs, err = sync.apply(configDocs); err != nil {
+		return "", fmt.Errorf("detect-drift: %w", err)
+	}
+
+	var objects []driftObject
+	docsDir := dag.Directory()
+	for _, doc := range append(configDocs, secretDocs...) {
+		if doc.Kind == "" || doc.Name == "" {
+			continue
+		}
+		ns := doc.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+		docsDir = docsDir.WithNewFile(fmt.Sprintf("%d.yaml", len(objects)), doc.Raw)
+		objects = append(objects, driftObject{
+			APIVersion: doc.APIVersion,
+			Kind:       doc.Kind,
+			Name:       doc.Name,
+			Namespace:  ns,
+		})
+	}
+
+	report := driftReport{
+		Namespace: namespace,
+		Added:     []driftObject{},
+		Changed:   []driftObject{},
+		Missing:   []driftObject{},
+		InSync:    []driftObject{},
+	}
+
+	if len(objects) > 0 {
+		out, err := kubectlContainer(kubeConfig).
+			WithDirectory("/work/docs", docsDir).
+			WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+			WithExec([]string{"sh", "-c", driftScript(objects, namespace)}).
+			Stdout(ctx)
+		if err != nil {
+			return "", fmt.Errorf("detect-drift: kubectl diff: %w", err)
+		}
+		if err := parseDriftOutput(out, objects, &report); err != nil {
+			return "", fmt.Errorf("detect-drift: %w", err)
+		}
+	}
+
+	report.Drifted = len(report.Added)+len(report.Changed)+len(report.Missing) > 0
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("detect-drift: marshal report: %w", err)
+	}
+
+	if failOnDrift && report.Drifted {
+		return string(data), fmt.Errorf("detect-drift: %d added, %d changed, %d missing object(s)",
+			len(report.Added), len(report.Changed), len(report.Missing))
+	}
+
+	return string(data), nil
+}
+
+// driftScript builds the shell script that probes every rendered document
+// and lists live objects of the rendered kinds. Every block is prefixed by a
+// marker line parsed by parseDriftOutput:
+//
+//	@@doc <index> <missing|changed|insync|error>
+//	@@live <kind> <name> <secret type>
+func driftScript(objects []driftObject, namespace string) string {
+	var b strings.Builder
+	b.WriteString("set +e\n")
+	for i, obj := range objects {
+		fmt.Fprintf(&b, `F=/work/docs/%[1]d.yaml
+if kubectl get -f "$F" -n %[2]s -o name >/dev/null 2>/tmp/get.err; then
+  kubectl diff -f "$F" -n %[2]s >/tmp/diff.out 2>&1
+  RC=$?
+  case "$RC" in
+    0) echo "@@doc %[1]d insync" ;;
+    1) echo "@@doc %[1]d changed"; cat /tmp/diff.out ;;
+    *) echo "@@doc %[1]d error"; cat /tmp/diff.out ;;
+  esac
+elif grep -qi "not found" /tmp/get.err; then
+  echo "@@doc %[1]d missing"
+else
+  echo "@@doc %[1]d error"; cat /tmp/get.err
+fi
+`, i, obj.Namespace)
+	}
+
+	seen := map[string]bool{}
+	for _, obj := range objects {
+		if seen[obj.Kind] {
+			continue
+		}
+		seen[obj.Kind] = true
+		fmt.Fprintf(&b, `kubectl get %s -n %s --no-headers --ignore-not-found \
+  -o custom-columns=KIND:.kind,NAME:.metadata.name,TYPE:.type 2>/dev/null | sed 's/^/@@live /'
+`, strings.ToLower(obj.Kind), namespace)
+	}
+	return b.String()
+}
+
+// parseDriftOutput sorts the probed objects into the report buckets and
+// collects live objects that have no rendered counterpart.
+func parseDriftOutput(out string, objects []driftObject, report *driftReport) error {
+	rendered := map[string]bool{}
+	for _, obj := range objects {
+		rendered[obj.Kind+"/"+obj.Name] = true
+	}
+
+	var (
+		current = -1
+		state   string
+		body    []string
+	)
+	flush := func() {
+		if current < 0 {
+			return
+		}
+		obj := objects[current]
+		text := strings.TrimSpace(strings.Join(body, "\n"))
+		switch state {
+		case "insync":
+			report.InSync = append(report.InSync, obj)
+		case "missing":
+			report.Missing = append(report.Missing, obj)
+		case "changed":
+			if obj.Kind == "Secret" {
+				obj.Redacted = true
+			} else {
+				obj.Diff = text
+			}
+			report.Changed = append(report.Changed, obj)
+		default:
+			if obj.Kind != "Secret" {
+				obj.Error = text
+			} else {
+				obj.Error = "kubectl error (output redacted)"
+			}
+			report.Errors = append(report.Errors, obj)
+		}
+		current, body = -1, nil
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@doc "):
+			flush()
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				return fmt.Errorf("unexpected probe marker %q", line)
+			}
+			idx, err := strconv.Atoi(fields[1])
+			if err != nil || idx < 0 || idx >= len(objects) {
+				return fmt.Errorf("unexpected probe index %q", fields[1])
+			}
+			current, state = idx, fields[2]
+		case strings.HasPrefix(line, "@@live "):
+			flush()
+			fields := strings.Fields(strings.TrimPrefix(line, "@@live "))
+			if len(fields) < 2 {
+				continue
+			}
+			kind, name := fields[0], fields[1]
+			if len(fields) > 2 && driftIgnoredSecretTypes[fields[2]] {
+				continue
+			}
+			if rendered[kind+"/"+name] {
+				continue
+			}
+			report.Added = append(report.Added, driftObject{
+				Kind:      kind,
+				Name:      name,
+				Namespace: report.Namespace,
+			})
+		default:
+			if current >= 0 {
+				body = append(body, line)
+			}
+		}
+	}
+	flush()
+	return nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"dagger/flux/internal/dagger"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fleetInventory is the YAML inventory consumed by BootstrapFleet.
+type fleetInventory struct {
+	Clusters []fleetCluster `yaml:"clusters"`
+}
+
+// fleetCluster is one cluster entry. Empty fields fall back to the
+// corresponding BootstrapFleet argument.
+type fleetCluster struct {
+	Name string `yaml:"name"`
+	// KubeconfigSecret is a Dagger secret URI (env://, file://, vault://, op://).
+	KubeconfigSecret string `yaml:"kubeconfigSecret,omitempty"`
+	// KubeconfigFile is a SOPS-encrypted kubeconfig, relative to inventoryDir.
+	KubeconfigFile   string `yaml:"kubeconfigFile,omitempty"`
+	ConfigParameters string `yaml:"configParameters,omitempty"`
+	DestinationPath  string `yaml:"destinationPath,omitempty"`
+	Namespace        string `yaml:"namespace,omitempty"`
+	FluxVersion      string `yaml:"fluxVersion,omitempty"`
+	OperatorVersion  string `yaml:"operatorVersion,omitempty"`
+	GitRef           string `yaml:"gitRef,omitempty"`
+}
+
+// fleetClusterResult is one row of the BootstrapFleet result matrix.
+type fleetClusterResult struct {
+	Name       string        `json:"name"`
+	Status     string        `json:"status"`
+	DurationMs int64         `json:"durationMs"`
+	Error      string        `json:"error,omitempty"`
+	Phases     []phaseResult `json:"phases,omitempty"`
+}
+
+// fleetResult is the JSON document returned by BootstrapFleet.
+type fleetResult struct {
+	Status    string               `json:"status"`
+	Succeeded int                  `json:"succeeded"`
+	Failed    int                  `json:"failed"`
+	Clusters  []fleetClusterResult `json:"clusters"`
+}
+
+// BootstrapFleet runs Bootstrap for every cluster in a YAML inventory with
+// bounded concurrency and returns a JSON result matrix (per-cluster status,
+// duration, error and per-phase results).
+//
+// Inventory format:
+//
+//	clusters:
+//	  - name: vre2
+//	    kubeconfigFile: kubeconfigs/vre2.enc.yaml   # SOPS, relative to --inventory-dir
+//	    destinationPath: clusters/labul/vsphere/vre2
+//	    configParameters: "interval=5m"
+//	  - name: vre3
+//	    kubeconfigSecret: vault://kv/kubeconfigs/vre3.kubeconfig
+//	    fluxVersion: "2.8.5"
+//
+// Every flag not set per cluster falls back to the corresponding argument
+// below. A failing cluster does not stop the others; check .status.
+func (m *Flux) BootstrapFleet(
+	ctx context.Context,
+	// YAML inventory file
+	inventory *dagger.File,
+	// Directory kubeconfigFile paths are resolved against
+	// +optional
+	inventoryDir *dagger.Directory,
+	// AGE private key for decrypting kubeconfigFile entries (defaults to sopsAgeKey)
+	// +optional
+	kubeconfigSopsKey *dagger.Secret,
+	// Maximum number of clusters bootstrapped in parallel
+	// +optional
+	// +default=4
+	concurrency int,
+	// OCI KCL module source for rendering Flux instance config
+	// +optional
+	// +default="ghcr.io/stuttgart-things/kcl-flux-instance:0.3.3"
+	ociSource string,
+	// Default comma-separated key=value pairs for KCL parameters
+	// +optional
+	configParameters string,
+	// Default Flux instance version
+	// +optional
+	// +default="2.8.5"
+	fluxVersion string,
+	// KCL entrypoint file name
+	// +optional
+	// +default="main.k"
+	entrypoint string,
+	// Whether KCL should also render Secret manifests
+	// +optional
+	// +default=false
+	renderSecrets bool,
+	// Git username for pull secret
+	// +optional
+	gitUsername *dagger.Secret,
+	// GitHub token for git pull secret
+	// +optional
+	gitPassword *dagger.Secret,
+	// AGE private key for SOPS decryption (applied to every cluster)
+	// +optional
+	sopsAgeKey *dagger.Secret,
+	// AGE public key for encrypting secrets before git commit
+	// +optional
+	agePublicKey *dagger.Secret,
+	// SOPS config file (.sops.yaml)
+	// +optional
+	sopsConfig *dagger.File,
+	// Default target namespace for Flux
+	// +optional
+	// +default="flux-system"
+	namespace string,
+	// Repository in "owner/repo" format
+	// +optional
+	repository string,
+	// Branch name for git operations
+	// +optional
+	// +default="main"
+	branchName string,
+	// Base branch for the pull request; when it differs from branchName the
+	// commit goes to branchName (created from baseBranch if missing)
+	// +optional
+	// +default="main"
+	baseBranch string,
+	// Open a pull request from branchName into baseBranch after committing
+	// +optional
+	// +default=false
+	createPR bool,
+	// Merge the pull request after creation
+	// +optional
+	// +default=false
+	mergePR bool,
+	// Merge method: "squash", "merge", or "rebase"
+	// +optional
+	// +default="squash"
+	mergeMethod string,
+	// Default destination path within the repository
+	// +optional
+	// +default="clusters/"
+	destinationPath string,
+	// Default Git reference for Flux source
+	// +optional
+	// +default="refs/heads/main"
+	gitRef string,
+	// GitHub token for git operations
+	// +optional
+	gitToken *dagger.Secret,
+	// Helmfile reference
+	// +optional
+	// +default="helmfile.yaml"
+	helmfileRef string,
+	// Directory containing the helmfile
+	// +optional
+	src *dagger.Directory,
+	// Apply rendered secrets to cluster
+	// +optional
+	// +default=true
+	applySecrets bool,
+	// Encrypt secrets with SOPS before git commit
+	// +optional
+	// +default=false
+	encryptSecrets bool,
+	// Commit rendered config to git
+	// +optional
+	// +default=false
+	commitToGit bool,
+	// Deploy Flux operator via Helmfile
+	// +optional
+	// +default=true
+	deployOperator bool,
+	// Wait for Flux reconciliation
+	// +optional
+	// +default=true
+	waitForReconciliation bool,
+	// Timeout for reconciliation check
+	// +optional
+	// +default="5m"
+	reconciliationTimeout string,
+	// Apply rendered config to cluster
+	// +optional
+	// +default=false
+	applyConfig bool,
+	// Flux CLI container image
+	// +optional
+	// +default="ghcr.io/fluxcd/flux-cli:v2.8.5"
+	fluxCliImage string,
+	// Default Flux operator version for Helmfile state values
+	// +optional
+	// +default="0.47.0"
+	operatorVersion string,
+) (string, error) {
+	if inventory == nil {
+		return "", fmt.Errorf("inventory is required")
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if kubeconfigSopsKey == nil {
+		kubeconfigSopsKey = sopsAgeKey
+	}
+
+	raw, err := inventory.Contents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("bootstrap-fleet: read inventory: %w", err)
+	}
+	var inv fleetInventory
+	if err := yaml.Unmarshal([]byte(raw), &inv); err != nil {
+		return "", fmt.Errorf("bootstrap-fleet: parse inventory: %w", err)
+	}
+	if len(inv.Clusters) == 0 {
+		return "", fmt.Errorf("bootstrap-fleet: inventory has no clusters")
+	}
+	seen := map[string]bool{}
+	for i, c := range inv.Clusters {
+		switch {
+		case c.Name == "":
+			return "", fmt.Errorf("bootstrap-fleet: cluster %d has no name", i)
+		case seen[c.Name]:
+			return "", fmt.Errorf("bootstrap-fleet: duplicate cluster name %q", c.Name)
+		case c.KubeconfigSecret == "" && c.KubeconfigFile == "":
+			return "", fmt.Errorf("bootstrap-fleet: cluster %q needs kubeconfigSecret or kubeconfigFile", c.Name)
+		case c.KubeconfigFile != "" && inventoryDir == nil:
+			return "", fmt.Errorf("bootstrap-fleet: cluster %q uses kubeconfigFile but --inventory-dir is not set", c.Name)
+		case c.KubeconfigFile != "" && kubeconfigSopsKey == nil:
+			return "", fmt.Errorf("bootstrap-fleet: cluster %q uses kubeconfigFile but no SOPS key is set", c.Name)
+		}
+		seen[c.Name] = true
+	}
+
+	results := make([]fleetClusterResult, len(inv.Clusters))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, c := range inv.Clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			res := fleetClusterResult{Name: c.Name}
+			defer func() {
+				res.DurationMs = time.Since(start).Milliseconds()
+				results[i] = res
+			}()
+
+			kubeConfig, err := fleetKubeconfig(ctx, c, inventoryDir, kubeconfigSopsKey)
+			if err != nil {
+				res.Status, res.Error = phaseFailed, err.Error()
+				return
+			}
+
+			out, err := m.Bootstrap(
+				ctx, ociSource,
+				orDefault(c.ConfigParameters, configParameters),
+				orDefault(c.FluxVersion, fluxVersion),
+				entrypoint, renderSecrets,
+				gitUsername, gitPassword, sopsAgeKey, agePublicKey, sopsConfig,
+				kubeConfig,
+				orDefault(c.Namespace, namespace),
+				repository, branchName, baseBranch, createPR, mergePR, mergeMethod,
+				orDefault(c.DestinationPath, destinationPath),
+				orDefault(c.GitRef, gitRef),
+				gitToken, helmfileRef, src,
+				applySecrets, encryptSecrets, commitToGit, deployOperator,
+				waitForReconciliation, reconciliationTimeout, applyConfig,
+				fluxCliImage,
+				orDefault(c.OperatorVersion, operatorVersion),
+				"git", "", "", "", false, nil, nil,
+				false, "json",
+			)
+			// A failed run returns its JSON report along with the error;
+			// only a missing report means bootstrap never got that far.
+			if err != nil && out == "" {
+				res.Status, res.Error = phaseFailed, err.Error()
+				return
+			}
+
+			var report runReport
+			if err := json.Unmarshal([]byte(out), &report); err != nil {
+				res.Status, res.Error = phaseFailed, fmt.Sprintf("parse bootstrap result: %v", err)
+				return
+			}
+			res.Status, res.Phases = report.Status, report.Phases
+			for _, p := range report.Phases {
+				if p.Status == phaseFailed {
+					res.Error = fmt.Sprintf("phase %d (%s): %s", p.Phase, p.Name, p.Error)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	fleet := fleetResult{Status: phaseOK, Clusters: results}
+	for _, r := range results {
+		if r.Status == phaseFailed {
+			fleet.Failed++
+			fleet.Status = phaseFailed
+		} else {
+			fleet.Succeeded++
+		}
+	}
+
+	data, err := json.MarshalIndent(fleet, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("bootstrap-fleet: marshal result: %w", err)
+	}
+	return string(data), nil
+}
+
+// fleetKubeconfig resolves a cluster's kubeconfig either from a Dagger
+// secret URI or by decrypting a SOPS file from the inventory directory.
+func fleetKubeconfig(ctx context.Context, c fleetCluster, inventoryDir *dagger.Directory, sopsKey *dagger.Secret) (*dagger.Secret, error) {
+	if c.KubeconfigSecret != "" {
+		return dag.Secret(c.KubeconfigSecret), nil
+	}
+	plaintext, err := dag.Secrets().Decrypt(ctx, sopsKey, inventoryDir.File(c.KubeconfigFile))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", c.KubeconfigFile, err)
+	}
+	return dag.SetSecret("fleet-kubeconfig-"+c.Name, plaintext), nil
+}
+
+// orDefault returns v, or def when v is empty.
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"time"
 
 	"dagger/flux/internal/dagger"
@@ -25,3 +26,85 @@ func parseTimeout(timeout string) int {
 	}
 	return int(d.Seconds())
 }
+
+// kubectlContainer returns a kubectl container with the kubeconfig mounted.
+func kubectlContainer(kubeConfig *dagger.Secret) *dagger.Container {
+	return dag.Container().
+		From("bitnami/kubectl:latest").
+		WithMountedSecret("/tmp/kubeconfig", kubeConfig, dagger.ContainerWithMountedSecretOpts{
+			Mode: 0444,
+		}).
+		WithEnvVariable("KUBECONFIG", "/tmp/kubeconfig")
+}
+
+// instanceKclParams builds the KCL parameter string for the FluxInstance
+// module from the bootstrap flags. configParameters is appended last so
+// callers can override any of the derived keys.
+func instanceKclParams(namespace, fluxVersion, repository, destinationPath, gitRef, configParameters string) string {
+	kclParams := "name=flux,namespace=" + namespace + ",version=" + fluxVersion
+	if repository != "" {
+		kclParams += ",gitUrl=https://github.com/" + repository
+	}
+	if destinationPath != "" {
+		kclParams += ",gitPath=" + destinationPath
+	}
+	if gitRef != "" {
+		kclParams += ",gitRef=" + gitRef
+	}
+	if configParameters != "" {
+		kclParams += "," + configParameters
+	}
+	return kclParams
+}
+
+// instanceSync is the FluxInstance sync source selected by the syncSource
+// flags of Bootstrap and the functions that re-render its config.
+type instanceSync struct {
+	source     string
+	url        string
+	tag        string
+	pullSecret string
+}
+
+// newInstanceSync validates the syncSource flags ("git" or "oci").
+func newInstanceSync(syncSource, ociURL, ociTag, ociPullSecret string) (instanceSync, error) {
+	switch syncSource {
+	case "", "git":
+		return instanceSync{source: "git"}, nil
+	case "oci":
+		if ociURL == "" {
+			return instanceSync{}, fmt.Errorf("syncSource=oci requires ociUrl")
+		}
+		if ociTag == "" {
+			ociTag = "latest"
+		}
+		return instanceSync{source: "oci", url: ociURL, tag: ociTag, pullSecret: ociPullSecret}, nil
+	default:
+		return instanceSync{}, fmt.Errorf("unknown syncSource %q (use git|oci)", syncSource)
+	}
+}
+
+// apply points every FluxInstance in docs at the OCI artifact when the
+// sync source is oci. Git docs are returned unchanged.
+func (s instanceSync) apply(docs []manifest) ([]manifest, error) {
+	if s.source != "oci" {
+		return docs, nil
+	}
+	out := make([]manifest, len(docs))
+	for i, d := range docs {
+		var err error
+		if out[i], err = d.withOCISync(s.url, s.tag, "./", s.pullSecret); err != nil {
+			return nil, fmt.Errorf("set OCI sync source: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// reconcileSourceCommand is the flux CLI command that reconciles the
+// flux-system source of this sync source.
+func (s instanceSync) reconcileSourceCommand() string {
+	if s.source == "oci" {
+		return "flux reconcile source oci flux-system"
+	}
+	return "flux reconcile source git flux-system"
+}
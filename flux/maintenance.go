@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger/flux/internal/dagger"
+)
+
+// fluxSuspendKinds are the kinds Suspend and Resume act on.
+var fluxSuspendKinds = []fluxKind{
+	{"GitRepository", "gitrepositories.source.toolkit.fluxcd.io"},
+	{"OCIRepository", "ocirepositories.source.toolkit.fluxcd.io"},
+	{"HelmRepository", "helmrepositories.source.toolkit.fluxcd.io"},
+	{"Bucket", "buckets.source.toolkit.fluxcd.io"},
+	{"Kustomization", "kustomizations.kustomize.toolkit.fluxcd.io"},
+	{"HelmRelease", "helmreleases.helm.toolkit.fluxcd.io"},
+}
+
+// suspendState is the file Suspend returns and Resume consumes.
+type suspendState struct {
+	SuspendedAt string               `json:"suspendedAt"`
+	Objects     []suspendStateObject `json:"objects"`
+}
+
+// suspendStateObject records one object's suspension before Suspend ran.
+type suspendStateObject struct {
+	Kind         string `json:"kind"`
+	Namespace    string `json:"namespace"`
+	Name         string `json:"name"`
+	WasSuspended bool   `json:"wasSuspended"`
+}
+
+// Suspend suspends Kustomizations, HelmReleases and sources (optionally
+// filtered by namespace and label) before a maintenance window.
+//
+// Returns a JSON state file recording every matched object and whether it
+// was already suspended. Pass it to Resume so only the objects suspended
+// here are resumed. When a patch fails, the objects already suspended are
+// resumed again: no state file is returned with the error.
+func (m *Flux) Suspend(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// Namespace to act on (empty acts on all namespaces)
+	// +optional
+	namespace string,
+	// Label selector (e.g. "app.kubernetes.io/part-of=apps")
+	// +optional
+	labelSelector string,
+) (*dagger.File, error) {
+	out, err := kubectlContainer(kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", listFluxObjectsScript(fluxSuspendKinds, namespace, labelSelector)}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("suspend: list flux objects: %w", err)
+	}
+	objects, err := parseFluxReadiness(out, nil)
+	if err != nil {
+		return nil, fmt.Errorf("suspend: %w", err)
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("suspend: no Flux objects match the filter")
+	}
+
+	state := suspendState{SuspendedAt: time.Now().UTC().Format(time.RFC3339)}
+	var toSuspend []suspendStateObject
+	for _, o := range objects {
+		obj := suspendStateObject{Kind: o.Kind, Namespace: o.Namespace, Name: o.Name, WasSuspended: o.Suspended}
+		state.Objects = append(state.Objects, obj)
+		if !o.Suspended {
+			toSuspend = append(toSuspend, obj)
+		}
+	}
+
+	if len(toSuspend) > 0 {
+		res, err := patchSuspend(ctx, kubeConfig, toSuspend, true)
+		if err != nil {
+			return nil, fmt.Errorf("suspend: %w", err)
+		}
+		if res.failed != nil {
+			// No state file is returned on error, so undo the objects
+			// suspended so far instead of leaving them unrecorded.
+			if len(res.patched) > 0 {
+				undo, err := patchSuspend(ctx, kubeConfig, res.patched, false)
+				if err == nil {
+					err = undo.failed
+				}
+				if err != nil {
+					return nil, fmt.Errorf("suspend: %w; resuming %d already suspended object(s) failed: %w",
+						res.failed, len(res.patched), err)
+				}
+			}
+			return nil, fmt.Errorf("suspend: %w (resumed the %d object(s) suspended before the failure)",
+				res.failed, len(res.patched))
+		}
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("suspend: marshal state: %w", err)
+	}
+	return dag.Directory().
+		WithNewFile("flux-suspend-state.json", string(data)).
+		File("flux-suspend-state.json"), nil
+}
+
+// Resume restores the suspension state recorded by Suspend: objects that
+// were already suspended before the maintenance window stay suspended,
+// all others are resumed and (with reconcile=true) reconciled right away.
+// Objects deleted in the meantime are reported and skipped.
+func (m *Flux) Resume(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// State file returned by Suspend
+	stateFile *dagger.File,
+	// Request an immediate reconciliation of resumed objects
+	// +optional
+	// +default=true
+	reconcile bool,
+) (string, error) {
+	raw, err := stateFile.Contents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("resume: read state: %w", err)
+	}
+	var state suspendState
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return "", fmt.Errorf("resume: parse state: %w", err)
+	}
+
+	var toResume []suspendStateObject
+	kept := 0
+	for _, o := range state.Objects {
+		if o.WasSuspended {
+			kept++
+			continue
+		}
+		toResume = append(toResume, o)
+	}
+	if len(toResume) == 0 {
+		return fmt.Sprintf("Nothing to resume (%d object(s) were already suspended before %s)", kept, state.SuspendedAt), nil
+	}
+
+	res, err := patchSuspend(ctx, kubeConfig, toResume, false)
+	if err == nil {
+		err = res.failed
+	}
+	if err != nil {
+		return "", fmt.Errorf("resume: %w (re-run with the same state file)", err)
+	}
+	missing := res.missing
+
+	results := []string{fmt.Sprintf("Resumed %d object(s), kept %d suspended", len(toResume)-len(missing), kept)}
+	if len(missing) > 0 {
+		results = append(results, "Not found (skipped): "+strings.Join(missing, ", "))
+	}
+
+	if reconcile {
+		if err := requestReconcile(ctx, kubeConfig, toResume); err != nil {
+			return "", fmt.Errorf("resume: objects resumed, but %w", err)
+		}
+		results = append(results, "Reconciliation requested")
+	}
+	return strings.Join(results, "\n"), nil
+}
+
+// ReconcileNow requests an immediate reconciliation of Kustomizations,
+// HelmReleases and sources (optionally filtered by namespace and label)
+// by setting the reconcile.fluxcd.io/requestedAt annotation. Suspended
+// objects are skipped.
+func (m *Flux) ReconcileNow(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// Namespace to act on (empty acts on all namespaces)
+	// +optional
+	namespace string,
+	// Label selector (e.g. "app.kubernetes.io/part-of=apps")
+	// +optional
+	labelSelector string,
+) (string, error) {
+	out, err := kubectlContainer(kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", listFluxObjectsScript(fluxSuspendKinds, namespace, labelSelector)}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("reconcile-now: list flux objects: %w", err)
+	}
+	objects, err := parseFluxReadiness(out, nil)
+	if err != nil {
+		return "", fmt.Errorf("reconcile-now: %w", err)
+	}
+
+	var targets []suspendStateObject
+	for _, o := range objects {
+		if !o.Suspended {
+			targets = append(targets, suspendStateObject{Kind: o.Kind, Namespace: o.Namespace, Name: o.Name})
+		}
+	}
+	if len(targets) == 0 {
+		return "No unsuspended Flux objects match the filter", nil
+	}
+	if err := requestReconcile(ctx, kubeConfig, targets); err != nil {
+		return "", fmt.Errorf("reconcile-now: %w", err)
+	}
+	return fmt.Sprintf("Reconciliation requested for %d object(s)", len(targets)), nil
+}
+
+// fluxResource returns the fully qualified resource name for a kind.
+func fluxResource(kind string) (string, error) {
+	for _, k := range fluxSuspendKinds {
+		if k.kind == kind {
+			return k.resource, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported kind %q", kind)
+}
+
+// patchResult is the outcome of patchSuspend.
+type patchResult struct {
+	// patched are the objects whose spec.suspend was set.
+	patched []suspendStateObject
+	// missing are the objects that no longer exist, as kind/namespace/name.
+	missing []string
+	// failed is the first patch error; later objects were not touched.
+	failed error
+}
+
+// patchSuspend sets spec.suspend on every object in one container run. It
+// stops at the first failing patch and reports which objects were patched
+// before it, so callers can undo a partial run.
+func patchSuspend(ctx context.Context, kubeConfig *dagger.Secret, objects []suspendStateObject, suspend bool) (patchResult, error) {
+	var b strings.Builder
+	for i, o := range objects {
+		resource, err := fluxResource(o.Kind)
+		if err != nil {
+			return patchResult{}, err
+		}
+		fmt.Fprintf(&b, "if kubectl get %[1]s %[2]s -n %[3]s >/dev/null 2>&1; then "+
+			"if msg=$(kubectl patch %[1]s %[2]s -n %[3]s --type=merge -p '{\"spec\":{\"suspend\":%[4]t}}' 2>&1); "+
+			"then echo '@@patched %[6]d'; else echo \"@@failed %[6]d $(echo \"$msg\" | head -n1)\"; exit 0; fi; "+
+			"else echo '@@missing %[5]s/%[3]s/%[2]s'; fi\n",
+			resource, o.Name, o.Namespace, suspend, o.Kind, i)
+	}
+
+	out, err := kubectlContainer(kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", b.String()}).
+		Stdout(ctx)
+	if err != nil {
+		return patchResult{}, fmt.Errorf("patch spec.suspend=%t: %w", suspend, err)
+	}
+	return parsePatchOutput(out, objects, suspend), nil
+}
+
+// parsePatchOutput reads the @@patched, @@missing and @@failed markers
+// written by the patchSuspend script.
+func parsePatchOutput(out string, objects []suspendStateObject, suspend bool) patchResult {
+	var res patchResult
+	for _, line := range nonEmptyLines(out) {
+		if ref, ok := strings.CutPrefix(line, "@@missing "); ok {
+			res.missing = append(res.missing, ref)
+			continue
+		}
+		if idx, ok := strings.CutPrefix(line, "@@patched "); ok {
+			if i, err := strconv.Atoi(idx); err == nil && i >= 0 && i < len(objects) {
+				res.patched = append(res.patched, objects[i])
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "@@failed "); ok {
+			idx, msg, _ := strings.Cut(rest, " ")
+			ref := idx
+			if i, err := strconv.Atoi(idx); err == nil && i >= 0 && i < len(objects) {
+				o := objects[i]
+				ref = o.Kind + "/" + o.Namespace + "/" + o.Name
+			}
+			res.failed = fmt.Errorf("patch spec.suspend=%t on %s: %s", suspend, ref, msg)
+		}
+	}
+	return res
+}
+
+// requestReconcile sets reconcile.fluxcd.io/requestedAt on every object,
+// which makes the Flux controllers reconcile it immediately. Objects that
+// no longer exist are skipped; any other annotate failure is collected
+// (with the same @@failed marker as patchSuspend) and returned.
+func requestReconcile(ctx context.Context, kubeConfig *dagger.Secret, objects []suspendStateObject) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var b strings.Builder
+	for i, o := range objects {
+		resource, err := fluxResource(o.Kind)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "if ! msg=$(kubectl get %[1]s %[2]s -n %[3]s --ignore-not-found -o name 2>&1); "+
+			"then echo \"@@failed %[5]d $(echo \"$msg\" | head -n1)\"; "+
+			"elif [ -n \"$msg\" ] && ! msg=$(kubectl annotate %[1]s %[2]s -n %[3]s --overwrite reconcile.fluxcd.io/requestedAt=%[4]s 2>&1); "+
+			"then echo \"@@failed %[5]d $(echo \"$msg\" | head -n1)\"; fi\n",
+			resource, o.Name, o.Namespace, now, i)
+	}
+
+	out, err := kubectlContainer(kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", now).
+		WithExec([]string{"sh", "-c", b.String()}).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("request reconciliation: %w", err)
+	}
+	if failed := parseReconcileFailures(out, objects); len(failed) > 0 {
+		return fmt.Errorf("request reconciliation failed for %d object(s): %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// parseReconcileFailures returns "<kind>/<namespace>/<name>: <error>" for
+// every @@failed marker written by the requestReconcile script.
+func parseReconcileFailures(out string, objects []suspendStateObject) []string {
+	var failed []string
+	for _, line := range nonEmptyLines(out) {
+		rest, ok := strings.CutPrefix(line, "@@failed ")
+		if !ok {
+			continue
+		}
+		idx, msg, _ := strings.Cut(rest, " ")
+		ref := idx
+		if i, err := strconv.Atoi(idx); err == nil && i >= 0 && i < len(objects) {
+			o := objects[i]
+			ref = o.Kind + "/" + o.Namespace + "/" + o.Name
+		}
+		failed = append(failed, ref+": "+msg)
+	}
+	return failed
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePatchOutput(t *testing.T) {
+	objects := []suspendStateObject{
+		{Kind: "Kustomization", Namespace: "apps", Name: "podinfo"},
+		{Kind: "HelmRelease", Namespace: "apps", Name: "gone"},
+		{Kind: "HelmRelease", Namespace: "apps", Name: "locked"},
+		{Kind: "GitRepository", Namespace: "apps", Name: "untouched"},
+	}
+	out := "@@patched 0\n@@missing HelmRelease/apps/gone\n@@failed 2 Error from server (Forbidden): helmreleases is forbidden\n"
+
+	res := parsePatchOutput(out, objects, true)
+	if len(res.patched) != 1 || res.patched[0].Name != "podinfo" {
+		t.Errorf("patched = %+v", res.patched)
+	}
+	if len(res.missing) != 1 || res.missing[0] != "HelmRelease/apps/gone" {
+		t.Errorf("missing = %v", res.missing)
+	}
+	if res.failed == nil || !strings.Contains(res.failed.Error(), "HelmRelease/apps/locked: Error from server (Forbidden)") {
+		t.Errorf("failed = %v", res.failed)
+	}
+}
+
+func TestParseReconcileFailures(t *testing.T) {
+	objects := []suspendStateObject{
+		{Kind: "Kustomization", Namespace: "apps", Name: "podinfo"},
+		{Kind: "HelmRelease", Namespace: "apps", Name: "locked"},
+	}
+	failed := parseReconcileFailures("@@failed 1 Error from server (Forbidden): helmreleases is forbidden\n", objects)
+	if len(failed) != 1 || failed[0] != "HelmRelease/apps/locked: Error from server (Forbidden): helmreleases is forbidden" {
+		t.Errorf("failed = %v", failed)
+	}
+}
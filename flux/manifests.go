@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifestClass groups rendered objects by how Bootstrap treats them.
+type manifestClass int
+
+const (
+	// classConfig objects are committed in plain text and applied in phase 5.
+	classConfig manifestClass = iota
+	// classSecret is a core v1 Secret: SOPS-encrypted before commit,
+	// applied in phase 6 and verified in phase 7.
+	classSecret
+	// classSealedSecret and classExternalSecret are safe to commit as-is
+	// but produce a Secret on the cluster that VerifySecrets can check.
+	classSealedSecret
+	classExternalSecret
+)
+
+// manifest is a single Kubernetes object decoded from multi-document YAML.
+type manifest struct {
+	APIVersion string
+	Kind       string
+	Name       string
+	Namespace  string
+	// Raw is the object re-encoded as a standalone YAML document.
+	Raw  string
+	node *yaml.Node
+}
+
+// parseManifests decodes multi-document YAML into individual objects.
+// Comment-only and empty documents are dropped, `---` separators may carry
+// trailing comments, and `kind: List` / `kind: *List` documents are
+// expanded into their items.
+func parseManifests(content string) ([]manifest, error) {
+	var out []manifest
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for i := 0; ; i++ {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("decode document %d: %w", i, err)
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			continue
+		}
+		root := doc.Content[0]
+
+		if kind := scalarAt(root, "kind"); strings.HasSuffix(kind, "List") {
+			if items := valueAt(root, "items"); items != nil && items.Kind == yaml.SequenceNode {
+				for _, item := range items.Content {
+					if item.Kind != yaml.MappingNode {
+						continue
+					}
+					m, err := newManifest(item)
+					if err != nil {
+						return nil, fmt.Errorf("document %d: %w", i, err)
+					}
+					out = append(out, m)
+				}
+				continue
+			}
+		}
+
+		m, err := newManifest(root)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func newManifest(root *yaml.Node) (manifest, error) {
+	m := manifest{
+		APIVersion: scalarAt(root, "apiVersion"),
+		Kind:       scalarAt(root, "kind"),
+		node:       root,
+	}
+	if meta := valueAt(root, "metadata"); meta != nil {
+		m.Name = scalarAt(meta, "name")
+		m.Namespace = scalarAt(meta, "namespace")
+	}
+	raw, err := encodeNode(root)
+	if err != nil {
+		return manifest{}, err
+	}
+	m.Raw = raw
+	return m, nil
+}
+
+// class reports how Bootstrap should treat the object.
+func (m manifest) class() manifestClass {
+	group := m.APIVersion
+	if i := strings.Index(group, "/"); i >= 0 {
+		group = group[:i]
+	} else {
+		group = ""
+	}
+	switch {
+	case m.Kind == "Secret" && group == "":
+		return classSecret
+	case m.Kind == "SealedSecret" && group == "bitnami.com":
+		return classSealedSecret
+	case m.Kind == "ExternalSecret" && group == "external-secrets.io":
+		return classExternalSecret
+	}
+	return classConfig
+}
+
+// secretName returns the name of the core Secret this object results in
+// on the cluster, or "" when it does not produce one.
+func (m manifest) secretName() string {
+	switch m.class() {
+	case classSecret:
+		return m.Name
+	case classSealedSecret:
+		if name := scalarAt(valueAt(valueAt(valueAt(m.node, "spec"), "template"), "metadata"), "name"); name != "" {
+			return name
+		}
+		return m.Name
+	case classExternalSecret:
+		if name := scalarAt(valueAt(valueAt(m.node, "spec"), "target"), "name"); name != "" {
+			return name
+		}
+		return m.Name
+	}
+	return ""
+}
+
+// withNamespace returns a copy of the manifest whose metadata.namespace is
+// set to namespace when it was empty. Objects that already carry a
+// namespace are returned unchanged.
+func (m manifest) withNamespace(namespace string) (manifest, error) {
+	if m.Namespace != "" || namespace == "" {
+		return m, nil
+	}
+	root := cloneNode(m.node)
+	meta := valueAt(root, "metadata")
+	if meta == nil {
+		meta = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "metadata"}, meta)
+	}
+	meta.Content = append(meta.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "namespace"},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: namespace})
+	return newManifest(root)
+}
+
+// withOCISync returns a copy of a FluxInstance whose spec.sync points at
+// an OCI artifact instead of a git repository. Other sync settings (e.g.
+// interval) are kept; pullSecret is replaced or removed.
+func (m manifest) withOCISync(url, ref, path, pullSecret string) (manifest, error) {
+	if m.Kind != "FluxInstance" {
+		return m, nil
+	}
+	root := cloneNode(m.node)
+	sync := ensureMapping(ensureMapping(root, "spec"), "sync")
+	setScalar(sync, "kind", "OCIRepository")
+	setScalar(sync, "url", url)
+	setScalar(sync, "ref", ref)
+	setScalar(sync, "path", path)
+	if pullSecret != "" {
+		setScalar(sync, "pullSecret", pullSecret)
+	} else {
+		deleteKey(sync, "pullSecret")
+	}
+	return newManifest(root)
+}
+
+// cloneNode deep-copies a YAML node tree.
+func cloneNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = cloneNode(child)
+	}
+	return &c
+}
+
+// splitRenderedDocs parses multi-document KCL output and splits it into
+// config and core Secret objects.
+func splitRenderedDocs(renderedContent string) (configDocs, secretDocs []manifest, err error) {
+	ms, err := parseManifests(renderedContent)
+	if err != nil {
+		return nil, nil, err
+	}
+	configDocs, secretDocs = splitManifests(ms)
+	return configDocs, secretDocs, nil
+}
+
+// splitManifests partitions objects into core Secrets and everything else.
+func splitManifests(ms []manifest) (configs, secrets []manifest) {
+	for _, m := range ms {
+		if m.class() == classSecret {
+			secrets = append(secrets, m)
+		} else {
+			configs = append(configs, m)
+		}
+	}
+	return configs, secrets
+}
+
+// joinManifests renders objects back into multi-document YAML.
+func joinManifests(ms []manifest) string {
+	docs := make([]string, 0, len(ms))
+	for _, m := range ms {
+		docs = append(docs, m.Raw)
+	}
+	return strings.Join(docs, "---\n")
+}
+
+func encodeNode(n *yaml.Node) (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return "", fmt.Errorf("encode document: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", fmt.Errorf("encode document: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// valueAt returns the value node for key in a mapping node, or nil.
+func valueAt(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// scalarAt returns the scalar value for key in a mapping node, or "".
+func scalarAt(n *yaml.Node, key string) string {
+	if v := valueAt(n, key); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
+
+// ensureMapping returns the mapping stored under key in n, creating it
+// when missing.
+func ensureMapping(n *yaml.Node, key string) *yaml.Node {
+	if v := valueAt(n, key); v != nil && v.Kind == yaml.MappingNode {
+		return v
+	}
+	v := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	deleteKey(n, key)
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	return v
+}
+
+// setScalar sets key in mapping node n to a string value.
+func setScalar(n *yaml.Node, key, value string) {
+	if v := valueAt(n, key); v != nil {
+		*v = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		return
+	}
+	n.Content = append(n.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// deleteKey removes key from mapping node n.
+func deleteKey(n *yaml.Node, key string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content = append(n.Content[:i], n.Content[i+2:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseManifests(t *testing.T) {
+	input := `--- # FluxInstance
+apiVersion: fluxcd.controlplane.io/v1
+kind: FluxInstance
+metadata:
+  name: flux
+  namespace: flux-system
+---
+# comment-only document
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: notes
+data:
+  text: "kind: Secret"
+---
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: Secret
+    metadata:
+      name: git-token
+    stringData:
+      password: s3cr3t
+  - apiVersion: bitnami.com/v1alpha1
+    kind: SealedSecret
+    metadata:
+      name: sealed
+      namespace: apps
+    spec:
+      template:
+        metadata:
+          name: sealed-target
+  - apiVersion: external-secrets.io/v1beta1
+    kind: ExternalSecret
+    metadata:
+      name: ext
+    spec:
+      target:
+        name: ext-target
+`
+
+	ms, err := parseManifests(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []struct {
+		kind, name, namespace, secretName string
+		class                             manifestClass
+	}{
+		{"FluxInstance", "flux", "flux-system", "", classConfig},
+		{"ConfigMap", "notes", "", "", classConfig},
+		{"Secret", "git-token", "", "git-token", classSecret},
+		{"SealedSecret", "sealed", "apps", "sealed-target", classSealedSecret},
+		{"ExternalSecret", "ext", "", "ext-target", classExternalSecret},
+	}
+	if len(ms) != len(expected) {
+		t.Fatalf("expected %d manifests, got %d", len(expected), len(ms))
+	}
+	for i, e := range expected {
+		m := ms[i]
+		if m.Kind != e.kind || m.Name != e.name || m.Namespace != e.namespace {
+			t.Errorf("manifest %d: expected %s/%s in %q, got %s/%s in %q", i, e.kind, e.name, e.namespace, m.Kind, m.Name, m.Namespace)
+		}
+		if m.class() != e.class {
+			t.Errorf("manifest %d: expected class %d, got %d", i, e.class, m.class())
+		}
+		if m.secretName() != e.secretName {
+			t.Errorf("manifest %d: expected secret name %q, got %q", i, e.secretName, m.secretName())
+		}
+	}
+
+	configs, secrets := splitManifests(ms)
+	if len(configs) != 4 || len(secrets) != 1 {
+		t.Fatalf("expected 4 config and 1 secret manifest, got %d and %d", len(configs), len(secrets))
+	}
+}
+
+func TestManifestWithNamespace(t *testing.T) {
+	ms, err := parseManifests("apiVersion: v1\nkind: Secret\nmetadata:\n  name: a\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m, err := ms[0].withNamespace("flux-system")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Namespace != "flux-system" {
+		t.Errorf("expected namespace flux-system, got %q", m.Namespace)
+	}
+	if !strings.Contains(m.Raw, "namespace: flux-system") {
+		t.Errorf("expected namespace in rendered document, got:\n%s", m.Raw)
+	}
+	if ms[0].Namespace != "" || strings.Contains(ms[0].Raw, "namespace:") {
+		t.Errorf("original manifest was modified")
+	}
+}
+
+func TestManifestWithOCISync(t *testing.T) {
+	ms, err := parseManifests(`apiVersion: fluxcd.controlplane.io/v1
+kind: FluxInstance
+metadata:
+  name: flux
+spec:
+  sync:
+    kind: GitRepository
+    url: https://github.com/my-org/fleet
+    ref: refs/heads/main
+    path: clusters/staging
+    pullSecret: git-token
+    interval: 5m
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m, err := ms[0].withOCISync("oci://registry:5000/flux/staging", "1.2.0", "./", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sync := valueAt(valueAt(m.node, "spec"), "sync")
+	for key, want := range map[string]string{
+		"kind":       "OCIRepository",
+		"url":        "oci://registry:5000/flux/staging",
+		"ref":        "1.2.0",
+		"path":       "./",
+		"interval":   "5m",
+		"pullSecret": "",
+	} {
+		if got := scalarAt(sync, key); got != want {
+			t.Errorf("spec.sync.%s: expected %q, got %q", key, want, got)
+		}
+	}
+	if !strings.Contains(ms[0].Raw, "GitRepository") {
+		t.Errorf("original manifest was modified")
+	}
+}
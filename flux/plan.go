@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// bootstrapPlan is the JSON document Bootstrap returns with --plan=true.
+type bootstrapPlan struct {
+	Namespace        string           `json:"namespace"`
+	KclParameterKeys []string         `json:"kclParameterKeys"`
+	Valid            bool             `json:"valid"`
+	Phases           []plannedPhase   `json:"phases"`
+	Commit           *plannedCommit   `json:"commit,omitempty"`
+	Artifact         *plannedArtifact `json:"artifact,omitempty"`
+	Apply            []plannedObject  `json:"apply"`
+}
+
+// plannedPhase describes whether a Bootstrap phase would run.
+type plannedPhase struct {
+	Phase  int    `json:"phase"`
+	Name   string `json:"name"`
+	Action string `json:"action"` // run | skip | fail
+	Reason string `json:"reason,omitempty"`
+}
+
+// plannedCommit describes what Phase 3 would push.
+type plannedCommit struct {
+	Repository       string   `json:"repository"`
+	Branch           string   `json:"branch"`
+	BaseBranch       string   `json:"baseBranch,omitempty"`
+	PullRequest      bool     `json:"pullRequest"`
+	Merge            string   `json:"merge,omitempty"`
+	DestinationPath  string   `json:"destinationPath"`
+	Files            []string `json:"files"`
+	SecretsEncrypted bool     `json:"secretsEncrypted"`
+}
+
+// plannedArtifact describes the OCI artifact Phase 3 would push.
+type plannedArtifact struct {
+	URL              string   `json:"url"`
+	Tag              string   `json:"tag"`
+	Files            []string `json:"files"`
+	SecretsEncrypted bool     `json:"secretsEncrypted"`
+}
+
+// plannedObject is a manifest Phase 5 or Phase 6 would apply.
+type plannedObject struct {
+	Phase     int    `json:"phase"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// bootstrapPlanInput carries the evaluated Bootstrap flags into
+// buildBootstrapPlan.
+type bootstrapPlanInput struct {
+	namespace        string
+	kclParams        string
+	configDocs       []manifest
+	secretDocs       []manifest
+	ageKeyPairResult string
+	haveAgePublicKey bool
+	repository       string
+	branchName       string
+	baseBranch       string
+	createPR         bool
+	mergePR          bool
+	mergeMethod      string
+	destinationPath  string
+	haveGitToken     bool
+	encryptSecrets   bool
+	commitToGit      bool
+	deployOperator   bool
+	applyConfig      bool
+	applySecrets     bool
+	waitForReconcile bool
+	operatorVersion  string
+	helmfileRef      string
+	syncSource       string
+	ociURL           string
+	ociTag           string
+	pushArtifact     bool
+}
+
+// buildBootstrapPlan evaluates the Bootstrap flags against the rendered
+// documents and returns the plan as indented JSON. It mirrors the phase
+// conditions in Bootstrap without touching git or the cluster.
+func buildBootstrapPlan(in bootstrapPlanInput) (string, error) {
+	plan := bootstrapPlan{
+		Namespace: in.namespace,
+		Valid:     true,
+		Apply:     []plannedObject{},
+	}
+	for _, p := range strings.Split(in.kclParams, ",") {
+		if parts := strings.SplitN(p, "=", 2); len(parts) == 2 {
+			plan.KclParameterKeys = append(plan.KclParameterKeys, parts[0])
+		}
+	}
+
+	add := func(phase int, name, action, reason string) {
+		if action == "fail" {
+			plan.Valid = false
+		}
+		plan.Phases = append(plan.Phases, plannedPhase{Phase: phase, Name: name, Action: action, Reason: reason})
+	}
+
+	hasSecrets := len(in.secretDocs) > 0
+
+	// Phase 0
+	if in.ageKeyPairResult != "" {
+		add(0, "ValidateAgeKeyPair", "run", in.ageKeyPairResult)
+	} else {
+		add(0, "ValidateAgeKeyPair", "skip", "sopsAgeKey or agePublicKey not provided")
+	}
+
+	// Phase 1
+	add(1, "RenderConfig", "run", fmt.Sprintf("rendered %d config doc(s) and %d secret doc(s)", len(in.configDocs), len(in.secretDocs)))
+
+	// Phase 2
+	switch {
+	case in.encryptSecrets && hasSecrets && !in.haveAgePublicKey:
+		add(2, "EncryptSecrets", "fail", "encryptSecrets=true but agePublicKey is nil")
+	case in.encryptSecrets && hasSecrets:
+		add(2, "EncryptSecrets", "run", "")
+	case hasSecrets:
+		add(2, "EncryptSecrets", "skip", "encryptSecrets=false")
+	default:
+		add(2, "EncryptSecrets", "skip", "no secrets to encrypt")
+	}
+
+	// Phase 3
+	switch {
+	case in.syncSource == "oci" && !in.pushArtifact:
+		add(3, "PushConfigArtifact", "skip", "pushArtifact=false")
+	case in.syncSource == "oci":
+		artifact := &plannedArtifact{
+			URL:              in.ociURL,
+			Tag:              in.ociTag,
+			Files:            []string{"config.yaml"},
+			SecretsEncrypted: in.encryptSecrets && hasSecrets,
+		}
+		reason := ""
+		if hasSecrets {
+			artifact.Files = append(artifact.Files, "secrets.yaml")
+			if !in.encryptSecrets {
+				reason = "secrets.yaml would be pushed unencrypted"
+			}
+		}
+		plan.Artifact = artifact
+		add(3, "PushConfigArtifact", "run", reason)
+	case !in.commitToGit:
+		add(3, "CommitConfig", "skip", "commitToGit=false")
+	case in.repository == "":
+		add(3, "CommitConfig", "fail", "commitToGit=true but repository is empty")
+	case !in.haveGitToken:
+		add(3, "CommitConfig", "fail", "commitToGit=true but gitToken is nil")
+	case in.createPR && in.branchName == in.baseBranch:
+		add(3, "CommitConfig", "fail", fmt.Sprintf("createPR=true but branchName equals baseBranch (%s)", in.baseBranch))
+	case in.mergePR && !in.createPR:
+		add(3, "CommitConfig", "fail", "mergePR=true requires createPR=true")
+	default:
+		commit := &plannedCommit{
+			Repository:       in.repository,
+			Branch:           in.branchName,
+			PullRequest:      in.createPR,
+			DestinationPath:  in.destinationPath,
+			Files:            []string{"config.yaml"},
+			SecretsEncrypted: in.encryptSecrets && hasSecrets,
+		}
+		if in.branchName != in.baseBranch {
+			commit.BaseBranch = in.baseBranch
+		}
+		if in.mergePR {
+			commit.Merge = in.mergeMethod
+		}
+		reason := ""
+		if hasSecrets {
+			commit.Files = append(commit.Files, "secrets.yaml")
+			if !in.encryptSecrets {
+				reason = "secrets.yaml would be committed unencrypted"
+			}
+		}
+		plan.Commit = commit
+		add(3, "CommitConfig", "run", reason)
+	}
+
+	// Phase 4
+	if in.deployOperator {
+		add(4, "DeployOperator", "run", fmt.Sprintf("helmfile apply %s (version=%s)", in.helmfileRef, in.operatorVersion))
+	} else {
+		add(4, "DeployOperator", "skip", "deployOperator=false")
+	}
+
+	// Phase 5
+	if in.applyConfig && len(in.configDocs) > 0 {
+		plan.Apply = append(plan.Apply, plannedObject{Phase: 5, Kind: "Namespace", Name: in.namespace})
+		plan.Apply = append(plan.Apply, plannedObjects(5, in.configDocs, in.namespace)...)
+		add(5, "ApplyConfig", "run", "")
+	} else {
+		add(5, "ApplyConfig", "skip", "applyConfig=false or no config docs")
+	}
+
+	// Phase 6 + 7
+	if in.applySecrets && hasSecrets {
+		plan.Apply = append(plan.Apply, plannedObjects(6, in.secretDocs, in.namespace)...)
+		add(6, "ApplySecrets", "run", "")
+		add(7, "VerifySecrets", "run", "")
+	} else {
+		add(6, "ApplySecrets", "skip", "applySecrets=false or no secrets")
+		add(7, "VerifySecrets", "skip", "no secrets to verify")
+	}
+
+	// Phase 8
+	if in.waitForReconcile {
+		add(8, "WaitForReconciliation", "run", "")
+	} else {
+		add(8, "WaitForReconciliation", "skip", "waitForReconciliation=false")
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal plan: %w", err)
+	}
+	return string(data), nil
+}
+
+// plannedObjects lists the objects in ms. Objects without a namespace
+// default to namespace, matching kubectl apply -n.
+func plannedObjects(phase int, ms []manifest, namespace string) []plannedObject {
+	var objs []plannedObject
+	for _, m := range ms {
+		ns := m.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+		objs = append(objs, plannedObject{Phase: phase, Kind: m.Kind, Name: m.Name, Namespace: ns})
+	}
+	return objs
+}
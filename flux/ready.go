@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"dagger/flux/internal/dagger"
+)
+
+// fluxKind maps a Flux kind to its fully qualified resource name.
+type fluxKind struct {
+	kind     string
+	resource string
+}
+
+// fluxReadyKinds are the kinds WaitForReady watches.
+var fluxReadyKinds = []fluxKind{
+	{"FluxInstance", "fluxinstances.fluxcd.controlplane.io"},
+	{"GitRepository", "gitrepositories.source.toolkit.fluxcd.io"},
+	{"OCIRepository", "ocirepositories.source.toolkit.fluxcd.io"},
+	{"Kustomization", "kustomizations.kustomize.toolkit.fluxcd.io"},
+	{"HelmRelease", "helmreleases.helm.toolkit.fluxcd.io"},
+}
+
+// fluxObjectStatus is the readiness of a single Flux object.
+type fluxObjectStatus struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Ready     bool
+	Suspended bool
+	Status    string
+	Reason    string
+	Message   string
+}
+
+func (s fluxObjectStatus) String() string {
+	line := fmt.Sprintf("%s %s/%s: Ready=%s", s.Kind, s.Namespace, s.Name, s.Status)
+	if s.Reason != "" {
+		line += " reason=" + s.Reason
+	}
+	if s.Message != "" {
+		line += " message=" + strings.ReplaceAll(s.Message, "\n", " ")
+	}
+	return line
+}
+
+// fluxObjectList is the subset of `kubectl get -o json` read by the waiter.
+type fluxObjectList struct {
+	Items []struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name       string `json:"name"`
+			Namespace  string `json:"namespace"`
+			Generation int64  `json:"generation"`
+		} `json:"metadata"`
+		Spec struct {
+			Suspend bool `json:"suspend"`
+		} `json:"spec"`
+		Status struct {
+			ObservedGeneration int64 `json:"observedGeneration"`
+			Conditions         []struct {
+				Type               string `json:"type"`
+				Status             string `json:"status"`
+				Reason             string `json:"reason"`
+				Message            string `json:"message"`
+				ObservedGeneration int64  `json:"observedGeneration"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// WaitForReady polls Kustomizations, HelmReleases, GitRepositories,
+// OCIRepositories and FluxInstances until every matching object reports
+// Ready=True for its current generation. Suspended objects are ignored.
+// Every object listed in names must exist, and at least one object must
+// match unless allowEmpty is set. Kinds whose CRD is not installed count
+// as empty; any other kubectl error fails immediately.
+//
+// On timeout it fails with one line per object that is not ready (or
+// missing), including the Ready condition's reason and message.
+func (m *Flux) WaitForReady(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// Namespace to watch (empty watches all namespaces)
+	// +optional
+	namespace string,
+	// Label selector (e.g. "app.kubernetes.io/part-of=apps")
+	// +optional
+	labelSelector string,
+	// Comma-separated object names to watch, as "name" or "Kind/name"
+	// (empty watches every object)
+	// +optional
+	names string,
+	// Maximum time to wait
+	// +optional
+	// +default="5m"
+	timeout string,
+	// Time between polls
+	// +optional
+	// +default="10s"
+	interval string,
+	// Succeed when no Flux object matches
+	// +optional
+	// +default=false
+	allowEmpty bool,
+) (string, error) {
+	deadline := time.Now().Add(time.Duration(parseTimeout(timeout)) * time.Second)
+	return waitForFluxReady(ctx, kubeConfig, namespace, labelSelector, names, deadline, interval, allowEmpty)
+}
+
+// waitForFluxReady is WaitForReady with an absolute deadline so callers can
+// share a timeout budget with earlier steps.
+func waitForFluxReady(
+	ctx context.Context,
+	kubeConfig *dagger.Secret,
+	namespace, labelSelector, names string,
+	deadline time.Time,
+	interval string,
+	allowEmpty bool,
+) (string, error) {
+	pause, err := time.ParseDuration(interval)
+	if err != nil || pause <= 0 {
+		pause = 10 * time.Second
+	}
+
+	wanted := map[string]bool{}
+	for _, n := range strings.Split(names, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			wanted[n] = true
+		}
+	}
+
+	script := listFluxObjectsScript(fluxReadyKinds, namespace, labelSelector)
+	var statuses []fluxObjectStatus
+	for attempt := 0; ; attempt++ {
+		out, err := kubectlContainer(kubeConfig).
+			WithEnvVariable("CACHE_BUSTER", fmt.Sprintf("%d-%d", time.Now().UnixNano(), attempt)).
+			WithExec([]string{"sh", "-c", script}).
+			Stdout(ctx)
+		if err != nil {
+			return "", fmt.Errorf("wait-for-ready: list flux objects: %w", err)
+		}
+		statuses, err = parseFluxReadiness(out, wanted)
+		if err != nil {
+			return "", fmt.Errorf("wait-for-ready: %w", err)
+		}
+
+		notReady := missingFluxObjects(statuses, wanted, namespace)
+		for _, s := range statuses {
+			if !s.Ready && !s.Suspended {
+				notReady = append(notReady, s)
+			}
+		}
+		empty := len(statuses) == 0 && !allowEmpty
+		if len(notReady) == 0 && !empty {
+			return readySummary(statuses), nil
+		}
+
+		if time.Now().Add(pause).After(deadline) {
+			if len(notReady) == 0 {
+				return "", fmt.Errorf("wait-for-ready: no Flux objects found after %d poll(s)", attempt+1)
+			}
+			lines := make([]string, 0, len(notReady))
+			for _, s := range notReady {
+				lines = append(lines, s.String())
+			}
+			return "", fmt.Errorf("wait-for-ready: %d Flux object(s) not ready after %d poll(s):\n%s",
+				len(notReady), attempt+1, strings.Join(lines, "\n"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+}
+
+// missingFluxObjects returns a NotFound status for every wanted "name" or
+// "Kind/name" that matches none of statuses.
+func missingFluxObjects(statuses []fluxObjectStatus, wanted map[string]bool, namespace string) []fluxObjectStatus {
+	found := map[string]bool{}
+	for _, s := range statuses {
+		found[s.Name] = true
+		found[s.Kind+"/"+s.Name] = true
+	}
+	if namespace == "" {
+		namespace = "*"
+	}
+	var missing []fluxObjectStatus
+	for w := range wanted {
+		if found[w] {
+			continue
+		}
+		kind, name, ok := strings.Cut(w, "/")
+		if !ok {
+			kind, name = "Object", w
+		}
+		missing = append(missing, fluxObjectStatus{
+			Kind:      kind,
+			Namespace: namespace,
+			Name:      name,
+			Status:    "Unknown",
+			Reason:    "NotFound",
+			Message:   "no matching object on the cluster",
+		})
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].Kind+"/"+missing[i].Name < missing[j].Kind+"/"+missing[j].Name
+	})
+	return missing
+}
+
+// listFluxObjectsScript lists every kind as JSON, one "@@kind" section per
+// kind. Kinds whose CRD is not installed produce an empty list; any other
+// kubectl error (RBAC, connection, API) fails the script.
+func listFluxObjectsScript(kinds []fluxKind, namespace, labelSelector string) string {
+	scope := "-A"
+	if namespace != "" {
+		scope = "-n " + namespace
+	}
+	selector := ""
+	if labelSelector != "" {
+		selector = fmt.Sprintf(" -l '%s'", labelSelector)
+	}
+
+	var b strings.Builder
+	for _, k := range kinds {
+		fmt.Fprintf(&b, `echo '@@kind %[1]s'
+if kubectl get %[2]s %[3]s%[4]s -o json >/tmp/list.json 2>/tmp/list.err; then
+  cat /tmp/list.json
+elif grep -q "the server doesn't have a resource type" /tmp/list.err; then
+  echo '{"items":[]}'
+else
+  echo "list %[1]s: $(cat /tmp/list.err)" >&2
+  exit 1
+fi
+`, k.kind, k.resource, scope, selector)
+	}
+	return b.String()
+}
+
+// parseFluxReadiness evaluates the output of listFluxObjectsScript. When wanted
+// is non-empty only objects whose "name" or "Kind/name" is in it are kept.
+func parseFluxReadiness(out string, wanted map[string]bool) ([]fluxObjectStatus, error) {
+	var statuses []fluxObjectStatus
+	sections := strings.Split(out, "@@kind ")
+	for _, section := range sections[1:] {
+		kind, body, _ := strings.Cut(section, "\n")
+		kind = strings.TrimSpace(kind)
+		body = strings.TrimSpace(body)
+		if body == "" {
+			continue
+		}
+
+		var list fluxObjectList
+		if err := json.Unmarshal([]byte(body), &list); err != nil {
+			return nil, fmt.Errorf("parse %s list: %w", kind, err)
+		}
+
+		for _, item := range list.Items {
+			name := item.Metadata.Name
+			if len(wanted) > 0 && !wanted[name] && !wanted[kind+"/"+name] {
+				continue
+			}
+			s := fluxObjectStatus{
+				Kind:      kind,
+				Namespace: item.Metadata.Namespace,
+				Name:      name,
+				Suspended: item.Spec.Suspend,
+				Status:    "Unknown",
+				Reason:    "NoReadyCondition",
+			}
+			for _, c := range item.Status.Conditions {
+				if c.Type != "Ready" {
+					continue
+				}
+				s.Status, s.Reason, s.Message = c.Status, c.Reason, c.Message
+				observed := c.ObservedGeneration
+				if observed == 0 {
+					observed = item.Status.ObservedGeneration
+				}
+				stale := item.Metadata.Generation > 0 && observed > 0 && observed < item.Metadata.Generation
+				s.Ready = c.Status == "True" && !stale
+				if c.Status == "True" && stale {
+					s.Reason = "StaleGeneration"
+					s.Message = fmt.Sprintf("observed generation %d, current %d", observed, item.Metadata.Generation)
+				}
+			}
+			statuses = append(statuses, s)
+		}
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Kind+"/"+a.Name < b.Kind+"/"+b.Name
+	})
+	return statuses, nil
+}
+
+// readySummary renders the final, all-ready state.
+func readySummary(statuses []fluxObjectStatus) string {
+	ready, suspended := 0, 0
+	lines := make([]string, 0, len(statuses))
+	for _, s := range statuses {
+		if s.Suspended {
+			suspended++
+			lines = append(lines, fmt.Sprintf("%s %s/%s: suspended", s.Kind, s.Namespace, s.Name))
+			continue
+		}
+		ready++
+		lines = append(lines, fmt.Sprintf("%s %s/%s: Ready", s.Kind, s.Namespace, s.Name))
+	}
+	header := fmt.Sprintf("All %d Flux object(s) Ready", ready)
+	if suspended > 0 {
+		header += fmt.Sprintf(" (%d suspended)", suspended)
+	}
+	return strings.Join(append([]string{header}, lines...), "\n")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFluxReadiness(t *testing.T) {
+	out := `@@kind FluxInstance
+{"items":[{"kind":"FluxInstance","metadata":{"name":"flux","namespace":"flux-system","generation":2},
+  "status":{"conditions":[{"type":"Ready","status":"True","reason":"ReconciliationSucceeded","observedGeneration":2}]}}]}
+@@kind GitRepository
+{"items":[]}
+@@kind OCIRepository
+{"items":[]}
+@@kind Kustomization
+{"items":[
+  {"kind":"Kustomization","metadata":{"name":"apps","namespace":"flux-system","generation":3},
+   "status":{"observedGeneration":2,"conditions":[{"type":"Ready","status":"True","reason":"ReconciliationSucceeded"}]}},
+  {"kind":"Kustomization","metadata":{"name":"infra","namespace":"flux-system","generation":1},
+   "status":{"conditions":[{"type":"Ready","status":"False","reason":"BuildFailed","message":"kustomize build failed:\nmissing file"}]}},
+  {"kind":"Kustomization","metadata":{"name":"paused","namespace":"flux-system"},"spec":{"suspend":true}}
+]}
+@@kind HelmRelease
+{"items":[{"kind":"HelmRelease","metadata":{"name":"podinfo","namespace":"apps"}}]}
+`
+
+	statuses, err := parseFluxReadiness(out, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]struct {
+		ready, suspended bool
+		reason           string
+	}{
+		"FluxInstance/flux":    {true, false, "ReconciliationSucceeded"},
+		"Kustomization/apps":   {false, false, "StaleGeneration"},
+		"Kustomization/infra":  {false, false, "BuildFailed"},
+		"Kustomization/paused": {false, true, "NoReadyCondition"},
+		"HelmRelease/podinfo":  {false, false, "NoReadyCondition"},
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("expected %d objects, got %d", len(expected), len(statuses))
+	}
+	for _, s := range statuses {
+		e, ok := expected[s.Kind+"/"+s.Name]
+		if !ok {
+			t.Errorf("unexpected object %s/%s", s.Kind, s.Name)
+			continue
+		}
+		if s.Ready != e.ready || s.Suspended != e.suspended || s.Reason != e.reason {
+			t.Errorf("%s/%s: expected ready=%v suspended=%v reason=%s, got ready=%v suspended=%v reason=%s",
+				s.Kind, s.Name, e.ready, e.suspended, e.reason, s.Ready, s.Suspended, s.Reason)
+		}
+	}
+	if statuses[0].Namespace != "apps" {
+		t.Errorf("expected results sorted by namespace, got %s first", statuses[0].Namespace)
+	}
+
+	for _, s := range statuses {
+		if s.Name == "infra" && !strings.Contains(s.String(), "message=kustomize build failed: missing file") {
+			t.Errorf("expected single-line message, got %q", s.String())
+		}
+	}
+
+	filtered, err := parseFluxReadiness(out, map[string]bool{"flux": true, "Kustomization/infra": true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(filtered) != 2 {
+		t.Errorf("expected 2 objects after filtering, got %d", len(filtered))
+	}
+}
+
+func TestMissingFluxObjects(t *testing.T) {
+	statuses := []fluxObjectStatus{{Kind: "Kustomization", Namespace: "flux-system", Name: "apps", Ready: true}}
+
+	missing := missingFluxObjects(statuses, map[string]bool{
+		"apps": true, "HelmRelease/podinfo": true, "infra": true,
+	}, "flux-system")
+	if len(missing) != 2 {
+		t.Fatalf("expected 2 missing objects, got %d", len(missing))
+	}
+	if got := missing[0].String(); got != "HelmRelease flux-system/podinfo: Ready=Unknown reason=NotFound message=no matching object on the cluster" {
+		t.Errorf("unexpected line %q", got)
+	}
+	if missing[1].Kind != "Object" || missing[1].Name != "infra" {
+		t.Errorf("unexpected missing object %+v", missing[1])
+	}
+
+	if missing := missingFluxObjects(statuses, map[string]bool{"Kustomization/apps": true}, ""); len(missing) != 0 {
+		t.Errorf("expected no missing objects, got %v", missing)
+	}
+}
+
+func TestListFluxObjectsScriptOnlyToleratesMissingCRDs(t *testing.T) {
+	script := listFluxObjectsScript(fluxReadyKinds[:1], "flux-system", "")
+	if strings.Contains(script, "2>/dev/null ||") {
+		t.Errorf("kubectl errors must not be swallowed:\n%s", script)
+	}
+	if !strings.Contains(script, "the server doesn't have a resource type") || !strings.Contains(script, "exit 1") {
+		t.Errorf("expected a missing-CRD check and a hard failure otherwise:\n%s", script)
+	}
+}
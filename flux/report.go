@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Phase status values used in phaseResult.Status.
+const (
+	phaseOK      = "ok"
+	phaseSkipped = "skipped"
+	phaseWarning = "warning"
+	phaseFailed  = "failed"
+)
+
+// phaseResult is the outcome of a single Bootstrap/Destroy phase.
+type phaseResult struct {
+	Phase      int      `json:"phase"`
+	Name       string   `json:"name"`
+	Status     string   `json:"status"`
+	Message    string   `json:"message,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	DurationMs int64    `json:"durationMs"`
+	Artifacts  []string `json:"artifacts,omitempty"`
+}
+
+// runReport collects phase results for a multi-phase workflow and renders
+// them either as the classic "Phase N: ..." text or as JSON.
+type runReport struct {
+	Operation string        `json:"operation"`
+	Status    string        `json:"status"`
+	Phases    []phaseResult `json:"phases"`
+}
+
+func newRunReport(operation string) *runReport {
+	return &runReport{Operation: operation, Status: phaseOK, Phases: []phaseResult{}}
+}
+
+func (r *runReport) ok(phase int, name string, start time.Time, msg string, artifacts ...string) {
+	r.Phases = append(r.Phases, phaseResult{
+		Phase: phase, Name: name, Status: phaseOK, Message: msg,
+		DurationMs: time.Since(start).Milliseconds(), Artifacts: artifacts,
+	})
+}
+
+func (r *runReport) skipped(phase int, name, reason string) {
+	r.Phases = append(r.Phases, phaseResult{Phase: phase, Name: name, Status: phaseSkipped, Message: reason})
+}
+
+func (r *runReport) warning(phase int, name string, start time.Time, err error) {
+	if r.Status == phaseOK {
+		r.Status = phaseWarning
+	}
+	r.Phases = append(r.Phases, phaseResult{
+		Phase: phase, Name: name, Status: phaseWarning, Error: err.Error(),
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+}
+
+// failed records a failing phase and returns err wrapped with the phase
+// number, matching the errors Bootstrap has always returned.
+func (r *runReport) failed(phase int, name string, start time.Time, err error) error {
+	r.Status = phaseFailed
+	r.Phases = append(r.Phases, phaseResult{
+		Phase: phase, Name: name, Status: phaseFailed, Error: err.Error(),
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+	return fmt.Errorf("phase %d: %w", phase, err)
+}
+
+// text renders the report in the historic newline-joined format.
+func (r *runReport) text() string {
+	lines := make([]string, 0, len(r.Phases))
+	for _, p := range r.Phases {
+		switch p.Status {
+		case phaseSkipped:
+			lines = append(lines, fmt.Sprintf("Phase %d: Skipped (%s)", p.Phase, p.Message))
+		case phaseWarning:
+			lines = append(lines, fmt.Sprintf("Phase %d: Warning — %s", p.Phase, p.Error))
+		case phaseFailed:
+			lines = append(lines, fmt.Sprintf("Phase %d: Failed — %s", p.Phase, p.Error))
+		default:
+			lines = append(lines, fmt.Sprintf("Phase %d: %s", p.Phase, p.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validateOutputFormat rejects unknown output formats before any phase runs.
+func validateOutputFormat(outputFormat string) error {
+	switch outputFormat {
+	case "", "text", "json":
+		return nil
+	}
+	return fmt.Errorf("unknown outputFormat %q (use text|json)", outputFormat)
+}
+
+// render returns the report in the requested output format together with
+// failErr, so a failed run always returns an error (non-zero exit) in both
+// modes. In "text" mode the report is dropped on failure, as before; in
+// "json" mode it is returned alongside the error for callers (such as
+// BootstrapFleet) that inspect per-phase status.
+func (r *runReport) render(outputFormat string, failErr error) (string, error) {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return "", err
+	}
+	if outputFormat != "json" {
+		if failErr != nil {
+			return "", failErr
+		}
+		return r.text(), nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal %s report: %w", r.Operation, err)
+	}
+	return string(data), failErr
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/flux/internal/dagger"
+)
+
+// RotateCredentials rotates the git pull credentials and/or the SOPS age
+// key of a running Flux installation without a full re-bootstrap.
+//
+// Phase order:
+//
+//	0: ValidateAgeKeyPair — new sopsAgeKey must match the new agePublicKey
+//	1: RenderSecrets — re-render the secret docs with the new values
+//	2: EncryptSecrets — re-encrypt secrets.yaml for the new age recipient
+//	3: ApplySecrets — replace the secrets on the cluster
+//	4: CommitConfig — commit config.yaml + secrets.yaml (commitToGit=true)
+//	5: Reconcile — flux reconcile source git|oci (and the flux-system
+//	   Kustomization when the age key changed, so decryption is exercised)
+//	6: Verify — secrets exist and every Flux object is Ready again
+//
+// The committed secrets.yaml is rebuilt from what is passed here, so pass
+// every credential it should contain, unchanged ones with their current
+// value. The cluster gets the new secrets before anything is committed, so
+// git never holds secrets.yaml encrypted for a key the cluster lacks; when
+// committing through a pull request the cluster already uses them before
+// the PR is merged. If the commit fails, re-run with the same inputs.
+func (m *Flux) RotateCredentials(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// New git username for the pull secret
+	// +optional
+	gitUsername *dagger.Secret,
+	// New GitHub token for the pull secret
+	// +optional
+	gitPassword *dagger.Secret,
+	// New AGE private key for SOPS decryption
+	// +optional
+	sopsAgeKey *dagger.Secret,
+	// New AGE public key (recipient) for re-encrypting secrets.yaml
+	// +optional
+	agePublicKey *dagger.Secret,
+	// SOPS config file (.sops.yaml)
+	// +optional
+	sopsConfig *dagger.File,
+	// OCI KCL module source for rendering Flux instance config
+	// +optional
+	// +default="ghcr.io/stuttgart-things/kcl-flux-instance:0.3.3"
+	ociSource string,
+	// Additional comma-separated key=value pairs for KCL parameters
+	// (use the same values as the original bootstrap)
+	// +optional
+	configParameters string,
+	// Flux instance version used when re-rendering config.yaml
+	// (empty reads the running version)
+	// +optional
+	fluxVersion string,
+	// KCL entrypoint file name
+	// +optional
+	// +default="main.k"
+	entrypoint string,
+	// Flux namespace
+	// +optional
+	// +default="flux-system"
+	namespace string,
+	// Repository in "owner/repo" format
+	// +optional
+	repository string,
+	// Destination path within the repository
+	// +optional
+	// +default="clusters/"
+	destinationPath string,
+	// Git reference for Flux source (e.g., refs/heads/main)
+	// +optional
+	// +default="refs/heads/main"
+	gitRef string,
+	// FluxInstance sync source: "git" or "oci" (as passed to bootstrap)
+	// +optional
+	// +default="git"
+	syncSource string,
+	// OCI artifact repository for syncSource=oci
+	// +optional
+	ociUrl string,
+	// OCI artifact tag for syncSource=oci
+	// +optional
+	// +default="latest"
+	ociTag string,
+	// Pull secret the cluster uses for the OCI registry
+	// +optional
+	ociPullSecret string,
+	// Commit the re-encrypted secrets.yaml to git
+	// +optional
+	// +default=false
+	commitToGit bool,
+	// GitHub token for git operations
+	// +optional
+	gitToken *dagger.Secret,
+	// Branch name for git operations
+	// +optional
+	// +default="main"
+	branchName string,
+	// Base branch for the pull request
+	// +optional
+	// +default="main"
+	baseBranch string,
+	// Open a pull request from branchName into baseBranch
+	// +optional
+	// +default=false
+	createPR bool,
+	// Merge the pull request after creation
+	// +optional
+	// +default=false
+	mergePR bool,
+	// Merge method: "squash", "merge", or "rebase"
+	// +optional
+	// +default="squash"
+	mergeMethod string,
+	// Timeout for the post-rotation health check
+	// +optional
+	// +default="5m"
+	reconciliationTimeout string,
+	// Flux CLI container image
+	// +optional
+	// +default="ghcr.io/fluxcd/flux-cli:v2.8.5"
+	fluxCliImage string,
+	// Output format: "text" or "json"
+	// +optional
+	// +default="text"
+	outputFormat string,
+) (string, error) {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return "", err
+	}
+	if gitPassword == nil && sopsAgeKey == nil {
+		return "", fmt.Errorf("rotate-credentials: nothing to rotate (set gitPassword and/or sopsAgeKey)")
+	}
+	if commitToGit && (repository == "" || gitToken == nil) {
+		return "", fmt.Errorf("rotate-credentials: commitToGit=true requires repository and gitToken")
+	}
+	sync, err := newInstanceSync(syncSource, ociUrl, ociTag, ociPullSecret)
+	if err != nil {
+		return "", fmt.Errorf("rotate-credentials: %w", err)
+	}
+
+	report := newRunReport("rotate-credentials")
+	deadline := time.Now().Add(time.Duration(parseTimeout(reconciliationTimeout)) * time.Second)
+
+	// =========================================================================
+	// Phase 0: Validate the new AGE key pair
+	// =========================================================================
+
+	start := time.Now()
+	if sopsAgeKey != nil && agePublicKey != nil {
+		msg, err := dag.Secrets().ValidateAgeKeyPair(ctx, sopsAgeKey, agePublicKey)
+		if err != nil {
+			return report.render(outputFormat, report.failed(0, "ValidateAgeKeyPair", start, err))
+		}
+		report.ok(0, "ValidateAgeKeyPair", start, msg)
+	} else {
+		report.skipped(0, "ValidateAgeKeyPair", "sopsAgeKey or agePublicKey not provided")
+	}
+
+	// =========================================================================
+	// Phase 1: Re-render secrets with the new values
+	// =========================================================================
+
+	start = time.Now()
+	if fluxVersion == "" {
+		running, err := readFluxVersions(ctx, kubeConfig, namespace)
+		if err != nil {
+			return report.render(outputFormat, report.failed(1, "RenderSecrets", start, err))
+		}
+		fluxVersion = running.flux
+	}
+
+	kclParams := instanceKclParams(namespace, fluxVersion, repository, destinationPath, gitRef, configParameters)
+	rendered, err := m.RenderConfig(ctx, ociSource, kclParams, entrypoint, true, gitUsername, gitPassword, sopsAgeKey)
+	if err != nil {
+		return report.render(outputFormat, report.failed(1, "RenderSecrets", start, err))
+	}
+	configDocs, secretDocs, err := splitRenderedDocs(rendered)
+	if err != nil {
+		return report.render(outputFormat, report.failed(1, "RenderSecrets", start, fmt.Errorf("parse rendered manifests: %w", err)))
+	}
+	if len(secretDocs) == 0 {
+		return report.render(outputFormat, report.failed(1, "RenderSecrets", start, fmt.Errorf("KCL rendered no secret documents")))
+	}
+	if configDocs, err = sync.apply(configDocs); err != nil {
+		return report.render(outputFormat, report.failed(1, "RenderSecrets", start, err))
+	}
+	var secretNames []string
+	for _, d := range secretDocs {
+		secretNames = append(secretNames, d.secretName())
+	}
+	report.ok(1, "RenderSecrets", start, fmt.Sprintf("rendered secret(s): %s", strings.Join(secretNames, ", ")))
+	secretContent := joinManifests(secretDocs)
+
+	// =========================================================================
+	// Phase 2: Re-encrypt secrets.yaml for the new recipient
+	// =========================================================================
+
+	start = time.Now()
+	var encrypted string
+	switch {
+	case !commitToGit:
+		report.skipped(2, "EncryptSecrets", "commitToGit=false")
+	case agePublicKey == nil:
+		return report.render(outputFormat, report.failed(2, "EncryptSecrets", start,
+			fmt.Errorf("commitToGit=true but agePublicKey is nil; refusing to commit plaintext secrets"))) // pragma: allowlist secret
+	default:
+		encrypted, err = dag.Secrets().EncryptString( // pragma: allowlist secret
+			ctx,
+			agePublicKey,
+			secretContent,
+			dagger.SecretsEncryptStringOpts{
+				FileExtension: "yaml",
+				SopsConfig:    sopsConfig,
+			},
+		)
+		if err != nil {
+			return report.render(outputFormat, report.failed(2, "EncryptSecrets", start, err))
+		}
+		report.ok(2, "EncryptSecrets", start, "Secrets re-encrypted with SOPS", "secrets.yaml (SOPS-encrypted)")
+	}
+
+	// =========================================================================
+	// Phase 3: Apply the new secrets
+	// =========================================================================
+
+	// Applied before the commit: git must never hold secrets encrypted for
+	// a recipient whose key the cluster does not have yet.
+	start = time.Now()
+	msg, err := m.ApplySecrets(ctx, secretContent, namespace, kubeConfig)
+	if err != nil {
+		return report.render(outputFormat, report.failed(3, "ApplySecrets", start, err))
+	}
+	report.ok(3, "ApplySecrets", start, msg)
+
+	// =========================================================================
+	// Phase 4: Commit re-encrypted secrets
+	// =========================================================================
+
+	start = time.Now()
+	if commitToGit {
+		msg, err = m.CommitConfig(ctx, joinManifests(configDocs), repository, branchName, destinationPath, gitToken, encrypted,
+			"Rotate Flux credentials", createPR, baseBranch,
+			fmt.Sprintf("Rotate Flux credentials: %s", strings.TrimSuffix(destinationPath, "/")), "",
+			mergePR, mergeMethod)
+		if err != nil {
+			return report.render(outputFormat, report.failed(4, "CommitConfig", start, err))
+		}
+		report.ok(4, "CommitConfig", start, msg)
+	} else {
+		report.skipped(4, "CommitConfig", "commitToGit=false")
+	}
+
+	// =========================================================================
+	// Phase 5: Reconcile with the new credentials
+	// =========================================================================
+
+	start = time.Now()
+	script := fmt.Sprintf("set -e\n%s -n %s --timeout=%s\n", sync.reconcileSourceCommand(), namespace, reconciliationTimeout)
+	if sopsAgeKey != nil {
+		script += fmt.Sprintf("flux reconcile kustomization flux-system -n %s --timeout=%s\n", namespace, reconciliationTimeout)
+	}
+	out, err := fluxCliContainer(fluxCliImage, kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return report.render(outputFormat, report.failed(5, "Reconcile", start, fmt.Errorf("reconcile with new credentials: %w", err)))
+	}
+	report.ok(5, "Reconcile", start, strings.TrimSpace(out))
+
+	// =========================================================================
+	// Phase 6: Verify secrets and reconciliation
+	// =========================================================================
+
+	start = time.Now()
+	verified, err := m.VerifySecrets(ctx, secretContent, namespace, kubeConfig)
+	if err != nil {
+		return report.render(outputFormat, report.failed(6, "Verify", start, err))
+	}
+	ready, err := waitForFluxReady(ctx, kubeConfig, namespace, "", "", deadline, "10s", false)
+	if err != nil {
+		return report.render(outputFormat, report.failed(6, "Verify", start, err))
+	}
+	report.ok(6, "Verify", start, verified+"\n"+ready)
+
+	return report.render(outputFormat, nil)
+}
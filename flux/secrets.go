@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"dagger/flux/internal/dagger"
 )
 
-// ApplySecrets applies secret manifests to the cluster.
+// ApplySecrets applies secret manifests to the cluster. Only Secret,
+// SealedSecret and ExternalSecret objects are applied; objects without a
+// namespace are placed in namespace.
 func (m *Flux) ApplySecrets(
 	ctx context.Context,
 	// Secret YAML content
@@ -20,16 +27,37 @@ func (m *Flux) ApplySecrets(
 	// Kubeconfig secret for cluster access
 	kubeConfig *dagger.Secret,
 ) (string, error) {
+	objs, err := parseManifests(secretContent)
+	if err != nil {
+		return "", fmt.Errorf("apply-secrets: %w", err)
+	}
+
+	var toApply []manifest
+	var skipped []string
+	for _, obj := range objs {
+		if obj.secretName() == "" {
+			skipped = append(skipped, obj.Kind+"/"+obj.Name)
+			continue
+		}
+		obj, err = obj.withNamespace(namespace)
+		if err != nil {
+			return "", fmt.Errorf("apply-secrets: %w", err)
+		}
+		toApply = append(toApply, obj)
+	}
+	if len(toApply) == 0 {
+		return "", fmt.Errorf("apply-secrets: no Secret, SealedSecret or ExternalSecret objects found")
+	}
+
 	secretFile := dag.Directory().
-		WithNewFile("secrets.yaml", secretContent).
+		WithNewFile("secrets.yaml", joinManifests(toApply)).
 		File("secrets.yaml")
 
-	_, err := dag.Kubernetes().Kubectl(
+	_, err = dag.Kubernetes().Kubectl(
 		ctx,
 		dagger.KubernetesKubectlOpts{
 			Operation:  "apply",
 			SourceFile: secretFile,
-			Namespace:  namespace,
 			KubeConfig: kubeConfig,
 		},
 	)
@@ -37,11 +65,18 @@ func (m *Flux) ApplySecrets(
 		return "", fmt.Errorf("apply-secrets: %w", err)
 	}
 
+	if len(skipped) > 0 {
+		return fmt.Sprintf("Secrets applied to cluster (skipped non-secret objects: %s)", strings.Join(skipped, ", ")), nil
+	}
 	return "Secrets applied to cluster", nil
 }
 
-// VerifySecrets auto-extracts secret names from the YAML and verifies they
-// exist in the cluster.
+// VerifySecrets extracts the Secrets the YAML results in (Secret names,
+// SealedSecret template names, ExternalSecret target names) and verifies
+// they exist in the cluster. For plain Secrets it also compares every
+// rendered key against the live Secret by SHA-256 of the value and
+// reports missing and stale keys. Live values never leave the container:
+// it hashes every key and returns only the SHA-256 digests.
 func (m *Flux) VerifySecrets(
 	ctx context.Context,
 	// Secret YAML content (multi-document)
@@ -53,52 +88,83 @@ func (m *Flux) VerifySecrets(
 	// Kubeconfig secret for cluster access
 	kubeConfig *dagger.Secret,
 ) (string, error) {
-	docs := strings.Split(secretContent, "---")
-	var secretNames []string
-	for _, doc := range docs {
-		if !strings.Contains(doc, "kind: Secret") {
+	objs, err := parseManifests(secretContent)
+	if err != nil {
+		return "", fmt.Errorf("verify-secrets: %w", err)
+	}
+
+	type secretRef struct {
+		namespace, name string
+		// expected is nil for SealedSecrets and ExternalSecrets, whose
+		// values are only known to their controllers.
+		expected map[string][]byte
+	}
+	var secretRefs []secretRef
+	namesByNamespace := map[string][]string{}
+	var namespaces []string
+	for _, obj := range objs {
+		name := obj.secretName()
+		if name == "" {
 			continue
 		}
-		inMetadata := false
-		for _, line := range strings.Split(doc, "\n") {
-			trimmed := strings.TrimSpace(line)
-			if trimmed == "metadata:" {
-				inMetadata = true
-				continue
-			}
-			if inMetadata && strings.HasPrefix(trimmed, "name:") {
-				name := strings.TrimSpace(strings.TrimPrefix(trimmed, "name:"))
-				name = strings.Trim(name, "\"'")
-				if name != "" {
-					secretNames = append(secretNames, name)
-				}
-				inMetadata = false
-				break
-			}
-			if inMetadata && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") && trimmed != "" {
-				inMetadata = false
+		ns := obj.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+		ref := secretRef{namespace: ns, name: name}
+		if obj.class() == classSecret {
+			if ref.expected, err = secretValues(obj); err != nil {
+				return "", fmt.Errorf("verify-secrets: %s: %w", name, err)
 			}
 		}
+		secretRefs = append(secretRefs, ref)
+		if _, ok := namesByNamespace[ns]; !ok {
+			namespaces = append(namespaces, ns)
+		}
+		namesByNamespace[ns] = append(namesByNamespace[ns], name)
 	}
 
-	if len(secretNames) == 0 {
+	if len(secretRefs) == 0 {
 		return "No secret names found in YAML", nil
 	}
 
-	var found, missing []string
-	for _, name := range secretNames {
-		_, err := dag.Container().
-			From("bitnami/kubectl:latest").
-			WithMountedSecret("/tmp/kubeconfig", kubeConfig, dagger.ContainerWithMountedSecretOpts{
-				Mode: 0444,
-			}).
-			WithEnvVariable("KUBECONFIG", "/tmp/kubeconfig").
-			WithExec([]string{"kubectl", "get", "secret", name, "-n", namespace, "-o", "name"}).
-			Stdout(ctx)
-		if err != nil {
+	var script strings.Builder
+	script.WriteString("set -e\n")
+	for _, ns := range namespaces {
+		for _, name := range namesByNamespace[ns] {
+			script.WriteString(liveSecretHashScript(ns, name))
+		}
+	}
+	liveRaw, err := kubectlContainer(kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", script.String()}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("verify-secrets: read live secrets: %w", err)
+	}
+	live := parseLiveSecretHashes(liveRaw)
+
+	var found, missing, stale, extra []string
+	for _, ref := range secretRefs {
+		name := ref.name
+		if ref.namespace != namespace {
+			name = ref.namespace + "/" + ref.name
+		}
+		liveValues, ok := live[ref.namespace+"/"+ref.name]
+		if !ok {
 			missing = append(missing, name)
-		} else {
-			found = append(found, name)
+			continue
+		}
+		found = append(found, name)
+		if ref.expected == nil {
+			continue
+		}
+		diff := compareSecretValues(ref.expected, liveValues)
+		if problems := diff.problems(); problems != "" {
+			stale = append(stale, fmt.Sprintf("%s (%s)", name, problems))
+		}
+		if len(diff.extra) > 0 {
+			extra = append(extra, fmt.Sprintf("%s: %s", name, strings.Join(diff.extra, ", ")))
 		}
 	}
 
@@ -106,10 +172,125 @@ func (m *Flux) VerifySecrets(
 	if len(found) > 0 {
 		result = append(result, fmt.Sprintf("Found secrets: %s", strings.Join(found, ", ")))
 	}
+	if len(extra) > 0 {
+		result = append(result, fmt.Sprintf("Keys only on cluster: %s", strings.Join(extra, "; ")))
+	}
+	if len(stale) > 0 {
+		result = append(result, fmt.Sprintf("Stale secrets: %s", strings.Join(stale, "; ")))
+	}
 	if len(missing) > 0 {
 		result = append(result, fmt.Sprintf("Missing secrets: %s", strings.Join(missing, ", ")))
+	}
+
+	switch {
+	case len(missing) > 0 && len(stale) > 0:
+		return strings.Join(result, "\n"), fmt.Errorf("verify-secrets: %d secret(s) missing, %d stale: %s; %s",
+			len(missing), len(stale), strings.Join(missing, ", "), strings.Join(stale, "; "))
+	case len(missing) > 0:
 		return strings.Join(result, "\n"), fmt.Errorf("verify-secrets: %d secret(s) missing: %s", len(missing), strings.Join(missing, ", "))
+	case len(stale) > 0:
+		return strings.Join(result, "\n"), fmt.Errorf("verify-secrets: %d secret(s) stale: %s", len(stale), strings.Join(stale, "; "))
 	}
 
 	return strings.Join(result, "\n"), nil
 }
+
+// secretValues returns the decoded key/value pairs of a rendered Secret.
+// stringData entries win over data entries with the same key, as on the
+// API server.
+func secretValues(m manifest) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	if data := valueAt(m.node, "data"); data != nil {
+		for i := 0; i+1 < len(data.Content); i += 2 {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data.Content[i+1].Value))
+			if err != nil {
+				return nil, fmt.Errorf("data.%s is not valid base64", data.Content[i].Value)
+			}
+			values[data.Content[i].Value] = decoded
+		}
+	}
+	if stringData := valueAt(m.node, "stringData"); stringData != nil {
+		for i := 0; i+1 < len(stringData.Content); i += 2 {
+			values[stringData.Content[i].Value] = []byte(stringData.Content[i+1].Value)
+		}
+	}
+	return values, nil
+}
+
+// liveSecretHashScript prints "@@secret <namespace>/<name>" for the
+// Secret, if it exists, followed by one "<key> <sha256>" line per data
+// key. The base64 values only pass through shell variables, so neither
+// stdout nor the container filesystem (and thus the Dagger cache) ever
+// holds a secret value.
+func liveSecretHashScript(namespace, name string) string {
+	return fmt.Sprintf(`raw=$(kubectl get secret %[2]s -n %[1]s --ignore-not-found -o go-template='{{"@@secret "}}{{.metadata.namespace}}/{{.metadata.name}}{{"\n"}}{{range $k, $v := .data}}{{$k}} {{$v}}{{"\n"}}{{end}}')
+printf '%%s\n' "$raw" | while read -r key value; do
+  [ -n "$key" ] || continue
+  if [ "$key" = "@@secret" ]; then echo "@@secret $value"; continue; fi
+  echo "$key $(printf '%%s' "$value" | base64 -d | sha256sum | cut -d' ' -f1)"
+done
+`, namespace, name)
+}
+
+// parseLiveSecretHashes parses the output of liveSecretHashScript into
+// hex SHA-256 digests per key, keyed by namespace/name.
+func parseLiveSecretHashes(raw string) map[string]map[string]string {
+	live := map[string]map[string]string{}
+	var current map[string]string
+	for _, line := range nonEmptyLines(raw) {
+		if ref, ok := strings.CutPrefix(line, "@@secret "); ok {
+			current = map[string]string{}
+			live[ref] = current
+			continue
+		}
+		key, sum, ok := strings.Cut(line, " ")
+		if ok && current != nil {
+			current[key] = sum
+		}
+	}
+	return live
+}
+
+// secretDiff is the key-level result of comparing a rendered Secret with
+// its live counterpart.
+type secretDiff struct {
+	missing []string // rendered, not on the cluster
+	stale   []string // on the cluster with a different value
+	extra   []string // on the cluster, not rendered
+}
+
+func (d secretDiff) problems() string {
+	var parts []string
+	if len(d.stale) > 0 {
+		parts = append(parts, "mismatched keys: "+strings.Join(d.stale, ", "))
+	}
+	if len(d.missing) > 0 {
+		parts = append(parts, "missing keys: "+strings.Join(d.missing, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// compareSecretValues compares the rendered values with the live hex
+// SHA-256 digests, so the live values are never needed.
+func compareSecretValues(expected map[string][]byte, live map[string]string) secretDiff {
+	var d secretDiff
+	for key, value := range expected {
+		liveSum, ok := live[key]
+		sum := sha256.Sum256(value)
+		switch {
+		case !ok:
+			d.missing = append(d.missing, key)
+		case hex.EncodeToString(sum[:]) != liveSum:
+			d.stale = append(d.stale, key)
+		}
+	}
+	for key := range live {
+		if _, ok := expected[key]; !ok {
+			d.extra = append(d.extra, key)
+		}
+	}
+	sort.Strings(d.missing)
+	sort.Strings(d.stale)
+	sort.Strings(d.extra)
+	return d
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareRenderedSecretWithLive(t *testing.T) {
+	ms, err := parseManifests(`apiVersion: v1
+kind: Secret
+metadata:
+  name: git-token
+data:
+  username: Z2l0
+  password: b2xk
+stringData:
+  password: new-token
+  url: https://github.com/my-org/fleet
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected, err := secretValues(ms[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(expected["password"]) != "new-token" {
+		t.Errorf("expected stringData to override data, got %q", expected["password"])
+	}
+
+	// sha256 of "git", "old" and "ca", as printed by liveSecretHashScript.
+	live := parseLiveSecretHashes(`@@secret flux-system/git-token
+username 9a881b9b9f23849475296a8cd768ea1965bc3152df7118e60c145975af6aa58a
+password cba06b5736faf67e54b07b561eae94395e774c517a7d910a54369e1263ccfbd4
+ca.crt 6959097001d10501ac7d54c0bdb8db61420f658f2922cc26e46d536119a31126
+`)
+	diff := compareSecretValues(expected, live["flux-system/git-token"])
+	if !reflect.DeepEqual(diff.stale, []string{"password"}) {
+		t.Errorf("expected stale [password], got %v", diff.stale)
+	}
+	if !reflect.DeepEqual(diff.missing, []string{"url"}) {
+		t.Errorf("expected missing [url], got %v", diff.missing)
+	}
+	if !reflect.DeepEqual(diff.extra, []string{"ca.crt"}) {
+		t.Errorf("expected extra [ca.crt], got %v", diff.extra)
+	}
+	if got := diff.problems(); got != "mismatched keys: password; missing keys: url" {
+		t.Errorf("unexpected problems summary %q", got)
+	}
+}
+
+func TestLiveSecretHashScript(t *testing.T) {
+	script := liveSecretHashScript("flux-system", "git-token")
+	for _, want := range []string{
+		"kubectl get secret git-token -n flux-system --ignore-not-found",
+		"base64 -d | sha256sum",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script does not contain %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "/tmp/") || strings.Contains(script, "-o json") {
+		t.Errorf("script must not write or print live values:\n%s", script)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"dagger/flux/internal/dagger"
+)
+
+// fluxVersions are the versions read from a running cluster.
+type fluxVersions struct {
+	flux     string
+	operator string
+}
+
+// Upgrade moves a running Flux installation to new Flux and/or operator
+// versions and rolls back when the upgrade does not become healthy.
+//
+// Phase order:
+//
+//	0: Read running FluxInstance and operator versions
+//	1: RenderConfig — re-render the FluxInstance with the target version
+//	2: DeployOperator — upgrade the operator (Helmfile)
+//	3: ApplyConfig — apply the re-rendered FluxInstance
+//	4: WaitForReconciliation — flux check within reconciliationTimeout
+//	5: Rollback — only on failure in 2-4 (when rollback=true): restore the
+//	   recorded operator version and FluxInstance version, then wait again
+//
+// Usage:
+//
+//	dagger call -m flux upgrade \
+//	  --kube-config file:///tmp/kubeconfig \
+//	  --flux-version 2.8.5 --operator-version 0.47.0 \
+//	  --helmfile-ref "git::https://github.com/stuttgart-things/helm.git@cicd/flux-operator.yaml.gotmpl"
+func (m *Flux) Upgrade(
+	ctx context.Context,
+	// Kubeconfig secret for cluster access
+	kubeConfig *dagger.Secret,
+	// Target Flux instance version (empty keeps the running version)
+	// +optional
+	fluxVersion string,
+	// Target Flux operator version (empty keeps the running version)
+	// +optional
+	operatorVersion string,
+	// OCI KCL module source for rendering Flux instance config
+	// +optional
+	// +default="ghcr.io/stuttgart-things/kcl-flux-instance:0.3.3"
+	ociSource string,
+	// Additional comma-separated key=value pairs for KCL parameters
+	// (use the same values as the original bootstrap)
+	// +optional
+	configParameters string,
+	// KCL entrypoint file name
+	// +optional
+	// +default="main.k"
+	entrypoint string,
+	// Target namespace for Flux
+	// +optional
+	// +default="flux-system"
+	namespace string,
+	// Repository in "owner/repo" format
+	// +optional
+	repository string,
+	// Destination path within the repository
+	// +optional
+	// +default="clusters/"
+	destinationPath string,
+	// Git reference for Flux source (e.g., refs/heads/main)
+	// +optional
+	// +default="refs/heads/main"
+	gitRef string,
+	// FluxInstance sync source: "git" or "oci" (use the same values as the
+	// original bootstrap)
+	// +optional
+	// +default="git"
+	syncSource string,
+	// OCI artifact repository for syncSource=oci
+	// +optional
+	ociUrl string,
+	// OCI artifact tag for syncSource=oci
+	// +optional
+	// +default="latest"
+	ociTag string,
+	// Pull secret the cluster uses for the OCI registry
+	// +optional
+	ociPullSecret string,
+	// Helmfile reference
+	// +optional
+	// +default="helmfile.yaml"
+	helmfileRef string,
+	// Directory containing the helmfile
+	// +optional
+	src *dagger.Directory,
+	// Timeout for the post-upgrade health check
+	// +optional
+	// +default="5m"
+	reconciliationTimeout string,
+	// Flux CLI container image
+	// +optional
+	// +default="ghcr.io/fluxcd/flux-cli:v2.8.5"
+	fluxCliImage string,
+	// Roll back to the recorded versions when the upgrade fails
+	// +optional
+	// +default=true
+	rollback bool,
+	// Output format: "text" or "json"
+	// +optional
+	// +default="text"
+	outputFormat string,
+) (string, error) {
+	if err := validateOutputFormat(outputFormat); err != nil {
+		return "", err
+	}
+	sync, err := newInstanceSync(syncSource, ociUrl, ociTag, ociPullSecret)
+	if err != nil {
+		return "", err
+	}
+
+	report := newRunReport("upgrade")
+
+	// =========================================================================
+	// Phase 0: Read running versions
+	// =========================================================================
+
+	start := time.Now()
+	previous, err := readFluxVersions(ctx, kubeConfig, namespace)
+	if err != nil {
+		return report.render(outputFormat, report.failed(0, "ReadVersions", start, err))
+	}
+	target := fluxVersions{flux: fluxVersion, operator: operatorVersion}
+	if target.flux == "" {
+		target.flux = previous.flux
+	}
+	if target.operator == "" {
+		target.operator = previous.operator
+	}
+	report.ok(0, "ReadVersions", start, fmt.Sprintf("running flux=%s operator=%s, target flux=%s operator=%s",
+		previous.flux, previous.operator, target.flux, target.operator))
+
+	if target == previous {
+		report.skipped(1, "RenderConfig", "already at target versions")
+		return report.render(outputFormat, nil)
+	}
+
+	// =========================================================================
+	// Phase 1: Re-render the FluxInstance with the target version
+	// =========================================================================
+
+	start = time.Now()
+	targetConfig, err := m.renderInstanceConfig(ctx, ociSource, configParameters, entrypoint,
+		namespace, target.flux, repository, destinationPath, gitRef, sync)
+	if err != nil {
+		return report.render(outputFormat, report.failed(1, "RenderConfig", start, err))
+	}
+	report.ok(1, "RenderConfig", start, fmt.Sprintf("rendered FluxInstance for version %s", target.flux))
+
+	// Phases 2-4 share one failure path: record the failure, then roll back.
+	upgradeErr := func() error {
+		// =====================================================================
+		// Phase 2: Upgrade the operator (Helmfile)
+		// =====================================================================
+
+		start := time.Now()
+		if target.operator != previous.operator {
+			if err := m.DeployOperator(ctx, kubeConfig, helmfileRef, src, "version="+target.operator); err != nil {
+				return report.failed(2, "DeployOperator", start, fmt.Errorf("upgrade flux operator: %w", err))
+			}
+			report.ok(2, "DeployOperator", start, fmt.Sprintf("Flux operator upgraded %s → %s", previous.operator, target.operator))
+		} else {
+			report.skipped(2, "DeployOperator", "operator already at target version")
+		}
+
+		// =====================================================================
+		// Phase 3: Apply the re-rendered FluxInstance
+		// =====================================================================
+
+		start = time.Now()
+		msg, err := m.ApplyConfig(ctx, targetConfig, namespace, kubeConfig)
+		if err != nil {
+			return report.failed(3, "ApplyConfig", start, err)
+		}
+		report.ok(3, "ApplyConfig", start, msg)
+
+		// =====================================================================
+		// Phase 4: Health gate
+		// =====================================================================
+
+		start = time.Now()
+		msg, err = m.WaitForReconciliation(ctx, namespace, kubeConfig, reconciliationTimeout, fluxCliImage)
+		if err != nil {
+			return report.failed(4, "WaitForReconciliation", start, err)
+		}
+		report.ok(4, "WaitForReconciliation", start, msg)
+		return nil
+	}()
+	if upgradeErr == nil {
+		return report.render(outputFormat, nil)
+	}
+
+	// =========================================================================
+	// Phase 5: Roll back to the recorded versions
+	// =========================================================================
+
+	if !rollback {
+		report.skipped(5, "Rollback", "rollback=false")
+		return report.render(outputFormat, upgradeErr)
+	}
+
+	start = time.Now()
+	if err := m.rollbackFlux(ctx, previous, target, kubeConfig, ociSource, configParameters, entrypoint,
+		namespace, repository, destinationPath, gitRef, sync, helmfileRef, src, reconciliationTimeout, fluxCliImage); err != nil {
+		rollbackErr := report.failed(5, "Rollback", start, err)
+		return report.render(outputFormat, fmt.Errorf("%w; rollback failed: %w", upgradeErr, rollbackErr))
+	}
+	report.ok(5, "Rollback", start, fmt.Sprintf("rolled back to flux=%s operator=%s", previous.flux, previous.operator))
+
+	return report.render(outputFormat,
+		fmt.Errorf("upgrade failed, rolled back to flux=%s operator=%s: %w", previous.flux, previous.operator, upgradeErr))
+}
+
+// rollbackFlux restores the operator and FluxInstance versions recorded
+// before an upgrade and waits for Flux to become healthy again.
+func (m *Flux) rollbackFlux(
+	ctx context.Context,
+	previous, target fluxVersions,
+	kubeConfig *dagger.Secret,
+	ociSource, configParameters, entrypoint, namespace string,
+	repository, destinationPath, gitRef string,
+	sync instanceSync,
+	helmfileRef string,
+	src *dagger.Directory,
+	reconciliationTimeout, fluxCliImage string,
+) error {
+	if target.operator != previous.operator {
+		if err := m.DeployOperator(ctx, kubeConfig, helmfileRef, src, "version="+previous.operator); err != nil {
+			return fmt.Errorf("restore flux operator %s: %w", previous.operator, err)
+		}
+	}
+
+	previousConfig, err := m.renderInstanceConfig(ctx, ociSource, configParameters, entrypoint,
+		namespace, previous.flux, repository, destinationPath, gitRef, sync)
+	if err != nil {
+		return fmt.Errorf("render FluxInstance %s: %w", previous.flux, err)
+	}
+	if _, err := m.ApplyConfig(ctx, previousConfig, namespace, kubeConfig); err != nil {
+		return fmt.Errorf("restore FluxInstance %s: %w", previous.flux, err)
+	}
+
+	if _, err := m.WaitForReconciliation(ctx, namespace, kubeConfig, reconciliationTimeout, fluxCliImage); err != nil {
+		return fmt.Errorf("flux unhealthy after rollback: %w", err)
+	}
+	return nil
+}
+
+// renderInstanceConfig renders the FluxInstance config (without secrets)
+// for the given version and sync source and returns only the non-secret
+// documents.
+func (m *Flux) renderInstanceConfig(
+	ctx context.Context,
+	ociSource, configParameters, entrypoint, namespace, fluxVersion string,
+	repository, destinationPath, gitRef string,
+	sync instanceSync,
+) (string, error) {
+	kclParams := instanceKclParams(namespace, fluxVersion, repository, destinationPath, gitRef, configParameters)
+	rendered, err := m.RenderConfig(ctx, ociSource, kclParams, entrypoint, false, nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	configDocs, _, err := splitRenderedDocs(rendered)
+	if err != nil {
+		return "", fmt.Errorf("parse rendered manifests: %w", err)
+	}
+	if len(configDocs) == 0 {
+		return "", fmt.Errorf("KCL rendered no config documents")
+	}
+	if configDocs, err = sync.apply(configDocs); err != nil {
+		return "", err
+	}
+	return joinManifests(configDocs), nil
+}
+
+// readFluxVersions reads the FluxInstance distribution version and the
+// flux-operator version (app.kubernetes.io/version label, falling back to
+// the image tag) from the cluster.
+func readFluxVersions(ctx context.Context, kubeConfig *dagger.Secret, namespace string) (fluxVersions, error) {
+	script := fmt.Sprintf(`set -e
+echo "flux=$(kubectl get fluxinstance flux -n %[1]s -o jsonpath='{.spec.distribution.version}')"
+echo "label=$(kubectl get deployment flux-operator -n %[1]s -o jsonpath='{.metadata.labels.app\.kubernetes\.io/version}')"
+echo "image=$(kubectl get deployment flux-operator -n %[1]s -o jsonpath='{.spec.template.spec.containers[0].image}')"
+`, namespace)
+
+	out, err := kubectlContainer(kubeConfig).
+		WithEnvVariable("CACHE_BUSTER", time.Now().UTC().Format(time.RFC3339Nano)).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+	if err != nil {
+		return fluxVersions{}, fmt.Errorf("read running versions: %w", err)
+	}
+
+	values := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			values[k] = v
+		}
+	}
+
+	v := fluxVersions{flux: values["flux"], operator: values["label"]}
+	if v.operator == "" {
+		if i := strings.LastIndex(values["image"], ":"); i >= 0 {
+			v.operator = values["image"][i+1:]
+		}
+	}
+	v.operator = strings.TrimPrefix(v.operator, "v")
+
+	if v.flux == "" {
+		return fluxVersions{}, fmt.Errorf("FluxInstance flux in %s has no spec.distribution.version", namespace)
+	}
+	if v.operator == "" {
+		return fluxVersions{}, fmt.Errorf("could not determine flux-operator version in %s", namespace)
+	}
+	return v, nil
+}